@@ -8,90 +8,111 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
-	"log"
-	"net/http"
-	"os"
 
 	tsapi "github.com/threatstack/ts/api"
 	"github.com/urfave/cli"
 )
 
 func getAgents(c *cli.Context, online bool) {
-	var agents []tsapi.Agent
-	var tokenString string
-	client := &http.Client{}
-	agentEndpoint := "/v2/agents?status=online"
+	params := tsapi.AgentListParams{Status: "online"}
 	if !online {
-		agentEndpoint = "/v2/agents?status=offline"
+		params.Status = "offline"
 	}
+	var agents []tsapi.Agent
+	var err error
+	if c.Bool("offline") {
+		agents, err = cachedAgents(c, params)
+	} else {
+		agents, err = queryAgents(c, params)
+	}
+	if err != nil {
+		flushPartial(c, err, agents, len(agents))
+	}
+
+	printJSON(c, agents)
+}
+
+// queryAgents - follow pagination tokens for an agents query and return every agent
+func queryAgents(c *cli.Context, params tsapi.AgentListParams) ([]tsapi.Agent, error) {
+	var agents []tsapi.Agent
 	for {
 		var response tsapi.AgentResponseRaw
-		req, err := tsBuildHTTPReq(c, "GET", agentEndpoint+tokenString, nil)
+		agentEndpoint := params.Endpoint()
+		req, err := tsBuildHTTPReq(c, "GET", agentEndpoint, nil)
 		if err != nil {
-			log.Fatalln(err)
+			return agents, err
 		}
-		resp, err := client.Do(req)
+		resp, err := apiClient.Do(req)
 		if err != nil {
-			log.Fatalln(err)
+			return agents, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return agents, err
+		}
+		if resp.StatusCode != 200 {
+			return agents, tsapi.NewAPIError(resp.StatusCode, agentEndpoint, body)
+		}
+		if err := json.Unmarshal(body, &response); err != nil {
+			return agents, err
 		}
-		defer resp.Body.Close()
-		if resp.StatusCode == 200 {
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				log.Fatalln(err)
-			}
-			if err := json.Unmarshal(body, &response); err != nil {
-				log.Fatalln(err)
-			}
 
-			agents = append(agents, response.Agents...)
+		agents = append(agents, response.Agents...)
 
-			if response.Token != "" {
-				tokenString = fmt.Sprintf("&token=%s", response.Token)
-			} else {
-				break
-			}
-		} else {
-			fmt.Printf("Unable to query %s - API responded with a %d", agentEndpoint+tokenString, resp.StatusCode)
-			os.Exit(1)
+		if response.Token == "" {
+			return agents, nil
 		}
+		params.Token = response.Token
 	}
-
-	ser, err := json.Marshal(agents)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	fmt.Println(string(ser))
 }
 
 func getAgent(c *cli.Context) {
 	if c.Args().Get(0) == "" {
-		cli.ShowSubcommandHelp(c)
-		fmt.Printf("\nERROR: Specify the Agent ID you want to look up as an argument.\n")
-		os.Exit(1)
+		failUsage(c, "Specify the Agent ID you want to look up as an argument.")
 	}
 
-	client := &http.Client{}
-	agentEndpoint := fmt.Sprintf("/v2/agents/%s", c.Args().Get(0))
+	agentEndpoint := tsapi.Path("v2", "agents", c.Args().Get(0))
 	req, err := tsBuildHTTPReq(c, "GET", agentEndpoint, nil)
 	if err != nil {
-		log.Fatalln(err)
+		fail(c, err)
 	}
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
-		log.Fatalln(err)
+		fail(c, err)
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode == 200 {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			log.Fatalln(err)
-		}
-		fmt.Printf("%s\n", body)
-	} else {
-		fmt.Printf("Unable to query %s - API responded with an HTTP/%d", agentEndpoint, resp.StatusCode)
-		os.Exit(1)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fail(c, err)
+	}
+	if resp.StatusCode != 200 {
+		fail(c, tsapi.NewAPIError(resp.StatusCode, agentEndpoint, body))
+	}
+	printBody(c, body)
+}
+
+// lookupAgent - fetch and decode a single agent by ID
+func lookupAgent(c *cli.Context, id string) (tsapi.Agent, error) {
+	var agent tsapi.Agent
+	agentEndpoint := tsapi.Path("v2", "agents", id)
+	req, err := tsBuildHTTPReq(c, "GET", agentEndpoint, nil)
+	if err != nil {
+		return agent, err
+	}
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return agent, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return agent, err
+	}
+	if resp.StatusCode != 200 {
+		return agent, tsapi.NewAPIError(resp.StatusCode, agentEndpoint, body)
 	}
+	err = json.Unmarshal(body, &agent)
+	return agent, err
 }
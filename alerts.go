@@ -11,167 +11,232 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
-	"net/http"
 	"os"
+	"strconv"
 
 	tsapi "github.com/threatstack/ts/api"
 	"github.com/urfave/cli"
 )
 
 func getAlerts(c *cli.Context, active bool) {
-	var alerts []tsapi.Alert
-	var tokenString string
-	client := &http.Client{}
-	alertsEndpoint := "/v2/alerts?status=active"
+	var errs []string
+	from, until, timeErrs := parseTimeRange(c)
+	errs = append(errs, timeErrs...)
+	severity, err := parseSeverity(c.String("severity"))
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		failUsage(c, "Unable to query alerts.", errs...)
+	}
+
+	params := tsapi.AlertListParams{
+		Status:   "active",
+		Severity: severity,
+		RuleID:   c.String("ruleid"),
+		From:     from,
+		Until:    until,
+	}
 	if !active {
-		alertsEndpoint = "/v2/alerts?status=dismissed"
+		params.Status = "dismissed"
 	}
-	if c.String("severity") != "" {
-		alertsEndpoint = alertsEndpoint + "&severity=" + c.String("severity")
+
+	var alerts []tsapi.Alert
+	if c.Bool("offline") {
+		alerts, err = cachedAlerts(c, params)
+	} else {
+		alerts, err = queryAlerts(c, params)
 	}
-	if c.String("from") != "" {
-		alertsEndpoint = alertsEndpoint + "&from=" + c.String("from")
+	if err != nil {
+		flushPartial(c, err, alerts, len(alerts))
 	}
-	if c.String("until") != "" {
-		alertsEndpoint = alertsEndpoint + "&until=" + c.String("until")
+
+	printJSON(c, alerts)
+}
+
+// fetchAlerts - follow pagination tokens for an alerts query and return every alert
+func fetchAlerts(c *cli.Context, params tsapi.AlertListParams) []tsapi.Alert {
+	alerts, err := queryAlerts(c, params)
+	if err != nil {
+		fail(c, err)
 	}
+	return alerts
+}
+
+// queryAlerts - like fetchAlerts, but returns errors for callers that want
+// to carry on (the forwarder, for one)
+func queryAlerts(c *cli.Context, params tsapi.AlertListParams) ([]tsapi.Alert, error) {
+	var alerts []tsapi.Alert
 	for {
 		var response tsapi.AlertResponseRaw
-		req, err := tsBuildHTTPReq(c, "GET", alertsEndpoint+tokenString, nil)
+		alertsEndpoint := params.Endpoint()
+		req, err := tsBuildHTTPReq(c, "GET", alertsEndpoint, nil)
 		if err != nil {
-			log.Fatalln(err)
+			return alerts, err
 		}
-		resp, err := client.Do(req)
+		resp, err := apiClient.Do(req)
+		if err != nil {
+			return alerts, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
 		if err != nil {
-			log.Fatalln(err)
+			return alerts, err
 		}
-		defer resp.Body.Close()
-		if resp.StatusCode == 200 {
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				log.Fatalln(err)
-			}
-			if err := json.Unmarshal(body, &response); err != nil {
-				log.Fatalln(err)
-			}
-
-			alerts = append(alerts, response.Alerts...)
-
-			if response.Token != "" {
-				tokenString = fmt.Sprintf("&token=%s", response.Token)
-			} else {
-				break
-			}
-		} else {
-			fmt.Printf("Unable to query %s - API responded with a %d", alertsEndpoint+tokenString, resp.StatusCode)
-			os.Exit(1)
+		if resp.StatusCode != 200 {
+			return alerts, tsapi.NewAPIError(resp.StatusCode, alertsEndpoint, body)
 		}
+		if err := json.Unmarshal(body, &response); err != nil {
+			return alerts, err
+		}
+
+		alerts = append(alerts, response.Alerts...)
+
+		if response.Token == "" {
+			return alerts, nil
+		}
+		params.Token = response.Token
 	}
+}
 
-	ser, err := json.Marshal(alerts)
-	if err != nil {
-		log.Fatalln(err)
+// parseAlertStatuses - turn a --status flag into the statuses to query
+func parseAlertStatuses(status string) ([]string, error) {
+	switch status {
+	case "all":
+		return []string{"active", "dismissed"}, nil
+	case "active", "dismissed":
+		return []string{status}, nil
 	}
-	fmt.Println(string(ser))
+	return nil, fmt.Errorf("Invalid status: %s (choose active, dismissed, or all)", status)
+}
+
+// parseSeverity - validate a severity flag; an empty flag means any severity
+func parseSeverity(severity string) (int, error) {
+	if severity == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(severity)
+	if err != nil || n < 1 || n > 3 {
+		return 0, fmt.Errorf("Invalid severity: %s (choose 1, 2, or 3)", severity)
+	}
+	return n, nil
 }
 
 func getAlert(c *cli.Context) {
 	if c.Args().Get(0) == "" {
-		cli.ShowSubcommandHelp(c)
-		fmt.Printf("\nERROR: Specify the Alert ID you want to look up as an argument.\n")
-		os.Exit(1)
+		failUsage(c, "Specify the Alert ID you want to look up as an argument.")
 	}
 
-	client := &http.Client{}
-	alertEndpoint := fmt.Sprintf("/v2/alerts/%s", c.Args().Get(0))
+	alertEndpoint := tsapi.Path("v2", "alerts", c.Args().Get(0))
 	req, err := tsBuildHTTPReq(c, "GET", alertEndpoint, nil)
 	if err != nil {
-		log.Fatalln(err)
+		fail(c, err)
 	}
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
-		log.Fatalln(err)
+		fail(c, err)
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode == 200 {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			log.Fatalln(err)
-		}
-		fmt.Printf("%s\n", body)
-	} else {
-		fmt.Printf("Unable to query %s - API responded with an HTTP/%d", alertEndpoint, resp.StatusCode)
-		os.Exit(1)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fail(c, err)
+	}
+	if resp.StatusCode != 200 {
+		fail(c, tsapi.NewAPIError(resp.StatusCode, alertEndpoint, body))
 	}
+	printBody(c, body)
 }
 
 func countAlerts(c *cli.Context) {
-	client := &http.Client{}
-	alertsEndpoint := "/v2/alerts/severity-counts"
-	var first_param bool = true
-	if c.String("from") != "" {
-		alertsEndpoint = alertsEndpoint + "?from=" + c.String("from")
-		first_param = false
-	}
-	if c.String("until") != "" {
-		if first_param {
-			alertsEndpoint = alertsEndpoint + "?until=" + c.String("until")
-			first_param = false
-		} else {
-			alertsEndpoint = alertsEndpoint + "&until=" + c.String("until")
-		}
+	from, until, errs := parseTimeRange(c)
+	if len(errs) > 0 {
+		failUsage(c, "Unable to count alerts.", errs...)
+	}
+
+	params := tsapi.AlertSeverityCountParams{
+		From:  from,
+		Until: until,
 	}
+	alertsEndpoint := params.Endpoint()
 	req, err := tsBuildHTTPReq(c, "GET", alertsEndpoint, nil)
 	if err != nil {
-		log.Fatalln(err)
+		fail(c, err)
 	}
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
-		log.Fatalln(err)
+		fail(c, err)
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode == 200 {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			log.Fatalln(err)
-		}
-		fmt.Printf("%s\n", body)
-	} else {
-		fmt.Printf("Unable to query %s - API responded with an HTTP/%d", alertsEndpoint, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fail(c, err)
+	}
+	if resp.StatusCode != 200 {
+		fail(c, tsapi.NewAPIError(resp.StatusCode, alertsEndpoint, body))
 	}
+	fmt.Printf("%s\n", body)
 }
 
 func getEvents(c *cli.Context) {
 	if c.Args().Get(0) == "" {
-		cli.ShowSubcommandHelp(c)
-		fmt.Printf("\nERROR: Specify the Alert ID you want to look up as an argument.\n")
-		os.Exit(1)
+		failUsage(c, "Specify the Alert ID you want to look up as an argument.")
+	}
+	if c.String("format") != "json" && c.String("format") != "timeline" {
+		failUsage(c, fmt.Sprintf("Invalid format: %s (choose json or timeline)", c.String("format")))
 	}
-	client := &http.Client{}
-	eventsEndpoint := fmt.Sprintf("/v2/alerts/%s/events", c.Args().Get(0))
+	eventsEndpoint := tsapi.Path("v2", "alerts", c.Args().Get(0), "events")
 	req, err := tsBuildHTTPReq(c, "GET", eventsEndpoint, nil)
 	if err != nil {
-		log.Fatalln(err)
+		fail(c, err)
 	}
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
-		log.Fatalln(err)
+		fail(c, err)
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode == 200 {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			log.Fatalln(err)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fail(c, err)
+	}
+	if resp.StatusCode != 200 {
+		fail(c, tsapi.NewAPIError(resp.StatusCode, eventsEndpoint, body))
+	}
+	if c.String("format") == "timeline" {
+		var response tsapi.AlertEventsResponseRaw
+		if err := json.Unmarshal(body, &response); err != nil {
+			fail(c, err)
 		}
-		fmt.Printf("%s\n", body)
+		writeEventTimeline(os.Stdout, response.Events)
 	} else {
-		fmt.Printf("Unable to query %s - API responded with an HTTP/%d", eventsEndpoint, resp.StatusCode)
+		fmt.Printf("%s\n", body)
+	}
+}
+
+// fetchAlertEvents - fetch and decode the contributing events for an alert
+func fetchAlertEvents(c *cli.Context, id string) ([]tsapi.AlertEvent, error) {
+	var response tsapi.AlertEventsResponseRaw
+	eventsEndpoint := tsapi.Path("v2", "alerts", id, "events")
+	req, err := tsBuildHTTPReq(c, "GET", eventsEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, tsapi.NewAPIError(resp.StatusCode, eventsEndpoint, body)
+	}
+	err = json.Unmarshal(body, &response)
+	return response.Events, err
 }
 
 func dismissAlertsByID(c *cli.Context) {
-	client := &http.Client{}
 	dismissAlertsEndpoint := "/v2/alerts/dismiss"
 	validInput := true
 	var errs []string
@@ -197,18 +262,12 @@ func dismissAlertsByID(c *cli.Context) {
 	}
 
 	if !validInput {
-		cli.ShowSubcommandHelp(c)
-		fmt.Printf("\nERROR: Unable to create alert dismissal request.\n")
-		for _, v := range errs {
-			fmt.Printf("         * %s\n", v)
-		}
-		os.Exit(1)
+		failUsage(c, "Unable to create alert dismissal request.", errs...)
 	}
 
 	file, err := os.Open(c.String("alertIDs"))
 	if err != nil {
-		fmt.Printf("\nERROR: Unable to read alert IDs from %s", c.String("alertIDs"))
-		os.Exit(1)
+		fail(c, fmt.Errorf("Unable to read alert IDs from %s: %w", c.String("alertIDs"), err))
 	}
 	var alertIDs []string
 	scanner := bufio.NewScanner(file)
@@ -226,8 +285,7 @@ func dismissAlertsByID(c *cli.Context) {
 	} else if c.String("dismissReason") == string(tsapi.DismissOther) {
 		inputDismissReason = tsapi.DismissOther
 	} else {
-		fmt.Printf("\nERROR: Invalid dismiss reason: %s", c.String("dismissReason"))
-		os.Exit(1)
+		failUsage(c, fmt.Sprintf("Invalid dismiss reason: %s", c.String("dismissReason")))
 	}
 
 	alertsToDismiss := tsapi.DismissAlertsByID{
@@ -238,40 +296,30 @@ func dismissAlertsByID(c *cli.Context) {
 
 	reqJSON, err := json.Marshal(alertsToDismiss)
 	if err != nil {
-		log.Fatalln(err)
+		fail(c, err)
 	}
 
 	req, err := tsBuildHTTPReq(c, "POST", dismissAlertsEndpoint, reqJSON)
 	if err != nil {
-		log.Fatalln(err)
+		fail(c, err)
 	}
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
-		log.Fatalln(err)
+		fail(c, err)
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalln(err)
+		fail(c, err)
 	}
 
-	if resp.StatusCode == 200 {
-		fmt.Printf("Successfully dismissed alerts\n")
-	} else {
-		fmt.Printf("Unable to dismiss alerts. The API responded with an HTTP/%d.\n", resp.StatusCode)
-		var errResponse tsapi.Error
-		if err := json.Unmarshal(body, &errResponse); err != nil {
-			log.Fatalln(err)
-		} else {
-			for _, v := range errResponse.Errors {
-				fmt.Printf("* %s\n", v)
-			}
-		}
+	if resp.StatusCode != 200 {
+		fail(c, fmt.Errorf("Unable to dismiss alerts. %w", tsapi.NewAPIError(resp.StatusCode, dismissAlertsEndpoint, body)))
 	}
+	fmt.Printf("Successfully dismissed alerts\n")
 }
 
 func dismissAlertsByQueryParameters(c *cli.Context) {
-	client := &http.Client{}
 	dismissAlertsEndpoint := "/v2/alerts/dismiss"
 	validInput := true
 	var errs []string
@@ -286,6 +334,18 @@ func dismissAlertsByQueryParameters(c *cli.Context) {
 		validInput = false
 	}
 
+	from, until, timeErrs := parseTimeRange(c)
+	if len(timeErrs) > 0 {
+		errs = append(errs, timeErrs...)
+		validInput = false
+	}
+
+	severity, err := parseSeverity(c.String("severity"))
+	if err != nil {
+		errs = append(errs, err.Error())
+		validInput = false
+	}
+
 	if c.String("severity") == "" && c.String("ruleID") == "" && c.String("agentID") == "" {
 		errs = append(errs, "Must include at least one of severity, ruleID, or agentID")
 		validInput = false
@@ -307,12 +367,7 @@ func dismissAlertsByQueryParameters(c *cli.Context) {
 	}
 
 	if !validInput {
-		cli.ShowSubcommandHelp(c)
-		fmt.Printf("\nERROR: Unable to create alert dismissal request.\n")
-		for _, v := range errs {
-			fmt.Printf("         * %s\n", v)
-		}
-		os.Exit(1)
+		failUsage(c, "Unable to create alert dismissal request.", errs...)
 	}
 
 	var inputDismissReason tsapi.DismissReason
@@ -325,14 +380,13 @@ func dismissAlertsByQueryParameters(c *cli.Context) {
 	} else if c.String("dismissReason") == string(tsapi.DismissOther) {
 		inputDismissReason = tsapi.DismissOther
 	} else {
-		fmt.Printf("\nERROR: Invalid dismiss reason: %s", c.String("dismissReason"))
-		os.Exit(1)
+		failUsage(c, fmt.Sprintf("Invalid dismiss reason: %s", c.String("dismissReason")))
 	}
 
 	alertsToDismiss := tsapi.DismissAlertsByQueryParameters{
-		From:              c.String("from"),
-		Until:             c.String("until"),
-		Severity:          c.Int("severity"),
+		From:              from,
+		Until:             until,
+		Severity:          severity,
 		RuleID:            c.String("ruleID"),
 		AgentID:           c.String("agentID"),
 		DismissReason:     inputDismissReason,
@@ -341,34 +395,25 @@ func dismissAlertsByQueryParameters(c *cli.Context) {
 
 	reqJSON, err := json.Marshal(alertsToDismiss)
 	if err != nil {
-		log.Fatalln(err)
+		fail(c, err)
 	}
 
 	req, err := tsBuildHTTPReq(c, "POST", dismissAlertsEndpoint, reqJSON)
 	if err != nil {
-		log.Fatalln(err)
+		fail(c, err)
 	}
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
-		log.Fatalln(err)
+		fail(c, err)
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalln(err)
+		fail(c, err)
 	}
 
-	if resp.StatusCode == 200 {
-		fmt.Printf("Successfully dismissed alerts\n")
-	} else {
-		fmt.Printf("Unable to dismiss alerts. The API responded with an HTTP/%d.\n", resp.StatusCode)
-		var errResponse tsapi.Error
-		if err := json.Unmarshal(body, &errResponse); err != nil {
-			log.Fatalln(err)
-		} else {
-			for _, v := range errResponse.Errors {
-				fmt.Printf("* %s\n", v)
-			}
-		}
+	if resp.StatusCode != 200 {
+		fail(c, fmt.Errorf("Unable to dismiss alerts. %w", tsapi.NewAPIError(resp.StatusCode, dismissAlertsEndpoint, body)))
 	}
+	fmt.Printf("Successfully dismissed alerts\n")
 }
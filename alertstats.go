@@ -0,0 +1,305 @@
+// ts - golang ts api client
+// alertstats.go: time-bucketed alert statistics and trend reporting
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	tsapi "github.com/threatstack/ts/api"
+	"github.com/urfave/cli"
+)
+
+// alertStats is the report produced by `ts alerts stats`
+type alertStats struct {
+	From                     string          `json:"from,omitempty"`
+	Until                    string          `json:"until,omitempty"`
+	Bucket                   string          `json:"bucket"`
+	GroupBy                  string          `json:"groupBy"`
+	Total                    int             `json:"total"`
+	Dismissed                int             `json:"dismissed"`
+	MeanTimeToDismissSeconds float64         `json:"meanTimeToDismissSeconds"`
+	Buckets                  []alertBucket   `json:"buckets"`
+	TopRules                 []alertStatItem `json:"topRules"`
+	TopAgents                []alertStatItem `json:"topAgents"`
+}
+
+// alertBucket holds the grouped alert counts for a single time bucket
+type alertBucket struct {
+	Start  string         `json:"start"`
+	Counts map[string]int `json:"counts"`
+}
+
+// alertStatItem is a single row in a top-N list
+type alertStatItem struct {
+	Key   string `json:"key"`
+	Label string `json:"label,omitempty"`
+	Count int    `json:"count"`
+}
+
+func alertStatsReport(c *cli.Context) {
+	validInput := true
+	var errs []string
+
+	bucketSize, err := parseBucket(c.String("bucket"))
+	if err != nil {
+		errs = append(errs, err.Error())
+		validInput = false
+	}
+
+	groupBy := c.String("group-by")
+	if _, ok := alertGroupKey(tsapi.Alert{}, groupBy); !ok {
+		errs = append(errs, fmt.Sprintf("Invalid group-by: %s (choose rule, agent, severity, title, or dataSource)", groupBy))
+		validInput = false
+	}
+
+	format := c.String("format")
+	if format != "table" && format != "csv" && format != "json" {
+		errs = append(errs, fmt.Sprintf("Invalid format: %s (choose table, csv, or json)", format))
+		validInput = false
+	}
+
+	from, until, timeErrs := parseTimeRange(c)
+	if len(timeErrs) > 0 {
+		errs = append(errs, timeErrs...)
+		validInput = false
+	}
+
+	statuses, err := parseAlertStatuses(c.String("status"))
+	if err != nil {
+		errs = append(errs, err.Error())
+		validInput = false
+	}
+
+	if !validInput {
+		failUsage(c, "Unable to build alert statistics.", errs...)
+	}
+
+	var alerts []tsapi.Alert
+	for _, status := range statuses {
+		params := tsapi.AlertListParams{
+			Status: status,
+			From:   from,
+			Until:  until,
+		}
+		alerts = append(alerts, fetchAlerts(c, params)...)
+	}
+
+	stats := computeAlertStats(alerts, bucketSize, groupBy, c.Int("top"))
+	stats.From = from
+	stats.Until = until
+	stats.Bucket = c.String("bucket")
+
+	// Agent IDs aren't very helpful in a weekly review, so look up the
+	// hostnames for the handful of agents that made the top-N list.
+	for i, item := range stats.TopAgents {
+		if agent, err := lookupAgent(c, item.Key); err == nil {
+			stats.TopAgents[i].Label = agent.Hostname
+		}
+	}
+
+	switch format {
+	case "json":
+		ser, err := json.Marshal(stats)
+		if err != nil {
+			fail(c, err)
+		}
+		fmt.Println(string(ser))
+	case "csv":
+		writeAlertStatsCSV(c, stats)
+	default:
+		writeAlertStatsTable(stats)
+	}
+}
+
+// computeAlertStats - bucket, group and rank a set of alerts
+func computeAlertStats(alerts []tsapi.Alert, bucketSize time.Duration, groupBy string, top int) alertStats {
+	stats := alertStats{GroupBy: groupBy, Total: len(alerts)}
+	buckets := make(map[time.Time]map[string]int)
+	rules := make(map[string]int)
+	ruleTitles := make(map[string]string)
+	agents := make(map[string]int)
+	var dismissTotal time.Duration
+
+	for _, alert := range alerts {
+		rules[alert.RuleID]++
+		if _, ok := ruleTitles[alert.RuleID]; !ok {
+			ruleTitles[alert.RuleID] = alert.Title
+		}
+		if alert.AgentID != "" {
+			agents[alert.AgentID]++
+		}
+
+		createdAt, err := time.Parse(time.RFC3339, alert.CreatedAt)
+		if err != nil {
+			continue
+		}
+		start := createdAt.UTC().Truncate(bucketSize)
+		if buckets[start] == nil {
+			buckets[start] = make(map[string]int)
+		}
+		key, _ := alertGroupKey(alert, groupBy)
+		buckets[start][key]++
+
+		if alert.IsDismissed && alert.DismissedAt != "" {
+			dismissedAt, err := time.Parse(time.RFC3339, alert.DismissedAt)
+			if err == nil && !dismissedAt.Before(createdAt) {
+				dismissTotal += dismissedAt.Sub(createdAt)
+				stats.Dismissed++
+			}
+		}
+	}
+
+	if stats.Dismissed > 0 {
+		stats.MeanTimeToDismissSeconds = (dismissTotal / time.Duration(stats.Dismissed)).Seconds()
+	}
+
+	var starts []time.Time
+	for start := range buckets {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+	for _, start := range starts {
+		stats.Buckets = append(stats.Buckets, alertBucket{
+			Start:  start.Format(time.RFC3339),
+			Counts: buckets[start],
+		})
+	}
+
+	stats.TopRules = topAlertStatItems(rules, top)
+	for i, item := range stats.TopRules {
+		stats.TopRules[i].Label = ruleTitles[item.Key]
+	}
+	stats.TopAgents = topAlertStatItems(agents, top)
+	return stats
+}
+
+// alertGroupKey - return the value an alert is grouped under, and false if
+// groupBy isn't something we know how to group on
+func alertGroupKey(alert tsapi.Alert, groupBy string) (string, bool) {
+	switch groupBy {
+	case "rule":
+		return alert.RuleID, true
+	case "agent":
+		return alert.AgentID, true
+	case "severity":
+		return strconv.Itoa(alert.Severity), true
+	case "title":
+		return alert.Title, true
+	case "dataSource":
+		return alert.DataSource, true
+	}
+	return "", false
+}
+
+// topAlertStatItems - sort counts descending and keep the first n
+func topAlertStatItems(counts map[string]int, n int) []alertStatItem {
+	var items []alertStatItem
+	for key, count := range counts {
+		items = append(items, alertStatItem{Key: key, Count: count})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Key < items[j].Key
+	})
+	if n > 0 && len(items) > n {
+		items = items[:n]
+	}
+	return items
+}
+
+// parseBucket - like time.ParseDuration, but also understands days and weeks
+func parseBucket(bucket string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	switch {
+	case strings.HasSuffix(bucket, "d") || strings.HasSuffix(bucket, "w"):
+		var n int
+		n, err = strconv.Atoi(bucket[:len(bucket)-1])
+		d = time.Duration(n) * 24 * time.Hour
+		if strings.HasSuffix(bucket, "w") {
+			d = d * 7
+		}
+	default:
+		d, err = time.ParseDuration(bucket)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("Invalid bucket size: %s (try 1h, 1d, or 1w)", bucket)
+	}
+	return d, nil
+}
+
+func writeAlertStatsTable(stats alertStats) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "Total alerts:\t%d\n", stats.Total)
+	fmt.Fprintf(w, "Dismissed:\t%d\n", stats.Dismissed)
+	fmt.Fprintf(w, "Mean time to dismiss:\t%s\n", time.Duration(stats.MeanTimeToDismissSeconds*float64(time.Second)).Round(time.Second))
+	fmt.Fprintf(w, "\n")
+
+	fmt.Fprintf(w, "BUCKET (%s)\t%s\tCOUNT\n", stats.Bucket, strings.ToUpper(stats.GroupBy))
+	for _, bucket := range stats.Buckets {
+		var keys []string
+		for key := range bucket.Counts {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s\t%s\t%d\n", bucket.Start, key, bucket.Counts[key])
+		}
+	}
+	fmt.Fprintf(w, "\n")
+
+	fmt.Fprintf(w, "TOP RULES\tTITLE\tCOUNT\n")
+	for _, item := range stats.TopRules {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", item.Key, item.Label, item.Count)
+	}
+	fmt.Fprintf(w, "\n")
+
+	fmt.Fprintf(w, "TOP AGENTS\tHOSTNAME\tCOUNT\n")
+	for _, item := range stats.TopAgents {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", item.Key, item.Label, item.Count)
+	}
+	w.Flush()
+}
+
+func writeAlertStatsCSV(c *cli.Context, stats alertStats) {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"section", "bucket", "key", "label", "count"})
+	w.Write([]string{"summary", "", "total", "", strconv.Itoa(stats.Total)})
+	w.Write([]string{"summary", "", "dismissed", "", strconv.Itoa(stats.Dismissed)})
+	w.Write([]string{"summary", "", "meanTimeToDismissSeconds", "", strconv.FormatFloat(stats.MeanTimeToDismissSeconds, 'f', 0, 64)})
+	for _, bucket := range stats.Buckets {
+		var keys []string
+		for key := range bucket.Counts {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			w.Write([]string{"bucket", bucket.Start, key, "", strconv.Itoa(bucket.Counts[key])})
+		}
+	}
+	for _, item := range stats.TopRules {
+		w.Write([]string{"topRule", "", item.Key, item.Label, strconv.Itoa(item.Count)})
+	}
+	for _, item := range stats.TopAgents {
+		w.Write([]string{"topAgent", "", item.Key, item.Label, strconv.Itoa(item.Count)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fail(c, err)
+	}
+}
@@ -9,8 +9,10 @@ package tsapi
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/tent/hawk-go"
 )
@@ -20,6 +22,43 @@ type Error struct {
 	Errors []string `json:"errors"`
 }
 
+// APIError is a failed (non-2xx) API response. Errors holds the API's
+// messages when the body has the usual {"errors": [...]} shape, and Body
+// holds anything else (a proxy's error page, say).
+type APIError struct {
+	StatusCode int
+	Endpoint   string
+	Errors     []string
+	Body       string
+}
+
+// NewAPIError builds an APIError from a response status and body
+func NewAPIError(statusCode int, endpoint string, body []byte) *APIError {
+	apiErr := &APIError{StatusCode: statusCode, Endpoint: endpoint}
+	var errResponse Error
+	if err := json.Unmarshal(body, &errResponse); err == nil && len(errResponse.Errors) > 0 {
+		apiErr.Errors = errResponse.Errors
+	} else {
+		apiErr.Body = strings.TrimSpace(string(body))
+	}
+	return apiErr
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("The API responded with an HTTP/%d", e.StatusCode)
+	if e.Endpoint != "" {
+		msg = msg + " for " + e.Endpoint
+	}
+	msg = msg + "."
+	for _, v := range e.Errors {
+		msg = msg + fmt.Sprintf("\n* %s", v)
+	}
+	if e.Body != "" {
+		msg = msg + "\n" + e.Body
+	}
+	return msg
+}
+
 // Config configures the API object
 type Config struct {
 	User string
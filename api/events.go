@@ -0,0 +1,123 @@
+// ts - golang ts api client
+// api/events.go: structs for the alert events endpoint
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package tsapi
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AlertEventsResponseRaw is the raw result returned from the API
+type AlertEventsResponseRaw struct {
+	Events []AlertEvent `json:"events"`
+}
+
+// AlertEvent is a single event that contributed to an alert. Events come
+// from several data sources, so most fields are only set for some of them;
+// everything the API sent is kept in Raw.
+type AlertEvent struct {
+	ID        string         `json:"id"`
+	EventType string         `json:"eventType"`
+	Timestamp EventTimestamp `json:"timestamp"`
+	AgentID   string         `json:"agentId"`
+	Hostname  string         `json:"hostname"`
+	Syscall   string         `json:"syscall"`
+	Command   string         `json:"command"`
+	Exe       string         `json:"exe"`
+	Args      EventArgs      `json:"args"`
+	Cwd       string         `json:"cwd"`
+	Filename  string         `json:"filename"`
+	User      string         `json:"user"`
+	UID       *int           `json:"uid"`
+	PID       int            `json:"pid"`
+	PPID      int            `json:"ppid"`
+	TTY       string         `json:"tty"`
+	Success   *bool          `json:"success"`
+
+	Raw map[string]interface{} `json:"-"`
+}
+
+// UnmarshalJSON decodes the known fields and keeps the full event in Raw.
+func (e *AlertEvent) UnmarshalJSON(data []byte) error {
+	type alertEvent AlertEvent
+	var event alertEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &event.Raw); err != nil {
+		return err
+	}
+	*e = AlertEvent(event)
+	return nil
+}
+
+// MarshalJSON re-encodes the event as the API sent it.
+func (e AlertEvent) MarshalJSON() ([]byte, error) {
+	if e.Raw != nil {
+		return json.Marshal(e.Raw)
+	}
+	type alertEvent AlertEvent
+	return json.Marshal(alertEvent(e))
+}
+
+// IsProcess reports whether the event describes a process being run.
+func (e AlertEvent) IsProcess() bool {
+	return e.PID != 0 && (strings.HasPrefix(e.Syscall, "exec") || e.EventType == "process")
+}
+
+// EventTimestamp is an event time, sent by the API as either epoch
+// milliseconds or an ISO-8601 string.
+type EventTimestamp struct {
+	time.Time
+}
+
+// UnmarshalJSON accepts epoch milliseconds or an ISO-8601 string.
+func (t *EventTimestamp) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var millis json.Number
+	if err := json.Unmarshal(data, &millis); err == nil {
+		n, err := strconv.ParseInt(string(millis), 10, 64)
+		if err != nil {
+			return err
+		}
+		t.Time = time.UnixMilli(n).UTC()
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
+
+// EventArgs are a process's arguments, sent by the API as either a list
+// or a single space-separated string.
+type EventArgs []string
+
+// UnmarshalJSON accepts a list of strings or a single string.
+func (a *EventArgs) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*a = list
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*a = strings.Fields(s)
+	return nil
+}
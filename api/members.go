@@ -13,11 +13,21 @@ type InvitePost struct {
 }
 
 type InviteResponse struct {
+	ID          string `json:"id"`
 	SentToEmail string `json:"sentToEmail"`
 	Role        string `json:"role"`
 	Status      string `json:"status"`
 }
 
+type InvitesResponseRaw struct {
+	Invites []InviteResponse `json:"invites"`
+}
+
+// MemberUpdate is the model for changing a member's role
+type MemberUpdate struct {
+	Role string `json:"role"`
+}
+
 type MembersResponseRaw struct {
 	Members []Member `json:"members"`
 }
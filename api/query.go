@@ -0,0 +1,135 @@
+// ts - golang ts api client
+// api/query.go: endpoint paths and typed query parameters
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package tsapi
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Path builds an endpoint path from its segments, escaping each one so
+// user-supplied IDs can't change the shape of the URL.
+func Path(segments ...string) string {
+	escaped := make([]string, len(segments))
+	for i, segment := range segments {
+		escaped[i] = url.PathEscape(segment)
+		// PathEscape leaves dots alone, but a bare "." or ".." segment
+		// would still walk the path.
+		if segment == "." || segment == ".." {
+			escaped[i] = strings.ReplaceAll(segment, ".", "%2E")
+		}
+	}
+	return "/" + strings.Join(escaped, "/")
+}
+
+// Endpoint combines a path with encoded query parameters.
+func Endpoint(path string, query url.Values) string {
+	if encoded := query.Encode(); encoded != "" {
+		return path + "?" + encoded
+	}
+	return path
+}
+
+// setIfNotEmpty only adds a parameter when it has a value, since the API
+// treats an empty parameter differently from a missing one.
+func setIfNotEmpty(query url.Values, key string, value string) {
+	if value != "" {
+		query.Set(key, value)
+	}
+}
+
+// AlertListParams are the query parameters for GET /v2/alerts
+type AlertListParams struct {
+	Status   string
+	Severity int
+	RuleID   string
+	AgentID  string
+	From     string
+	Until    string
+	Token    string
+}
+
+// Values encodes the parameters that have been set.
+func (p AlertListParams) Values() url.Values {
+	query := url.Values{}
+	setIfNotEmpty(query, "status", p.Status)
+	if p.Severity != 0 {
+		query.Set("severity", strconv.Itoa(p.Severity))
+	}
+	setIfNotEmpty(query, "ruleId", p.RuleID)
+	setIfNotEmpty(query, "agentId", p.AgentID)
+	setIfNotEmpty(query, "from", p.From)
+	setIfNotEmpty(query, "until", p.Until)
+	setIfNotEmpty(query, "token", p.Token)
+	return query
+}
+
+// Endpoint returns the full alerts endpoint for these parameters.
+func (p AlertListParams) Endpoint() string {
+	return Endpoint(Path("v2", "alerts"), p.Values())
+}
+
+// AlertSeverityCountParams are the query parameters for
+// GET /v2/alerts/severity-counts
+type AlertSeverityCountParams struct {
+	From  string
+	Until string
+}
+
+// Values encodes the parameters that have been set.
+func (p AlertSeverityCountParams) Values() url.Values {
+	query := url.Values{}
+	setIfNotEmpty(query, "from", p.From)
+	setIfNotEmpty(query, "until", p.Until)
+	return query
+}
+
+// Endpoint returns the full severity counts endpoint for these parameters.
+func (p AlertSeverityCountParams) Endpoint() string {
+	return Endpoint(Path("v2", "alerts", "severity-counts"), p.Values())
+}
+
+// AgentListParams are the query parameters for GET /v2/agents
+type AgentListParams struct {
+	Status string
+	Token  string
+}
+
+// Values encodes the parameters that have been set.
+func (p AgentListParams) Values() url.Values {
+	query := url.Values{}
+	setIfNotEmpty(query, "status", p.Status)
+	setIfNotEmpty(query, "token", p.Token)
+	return query
+}
+
+// Endpoint returns the full agents endpoint for these parameters.
+func (p AgentListParams) Endpoint() string {
+	return Endpoint(Path("v2", "agents"), p.Values())
+}
+
+// AuditLogParams are the query parameters for GET /v2/auditlogs
+type AuditLogParams struct {
+	From  string
+	Until string
+	Token string
+}
+
+// Values encodes the parameters that have been set.
+func (p AuditLogParams) Values() url.Values {
+	query := url.Values{}
+	setIfNotEmpty(query, "from", p.From)
+	setIfNotEmpty(query, "until", p.Until)
+	setIfNotEmpty(query, "token", p.Token)
+	return query
+}
+
+// Endpoint returns the full audit log endpoint for these parameters.
+func (p AuditLogParams) Endpoint() string {
+	return Endpoint(Path("v2", "auditlogs"), p.Values())
+}
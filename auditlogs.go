@@ -0,0 +1,74 @@
+// ts - golang ts api client
+// auditlogs.go: list audit log records for your organization
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	tsapi "github.com/threatstack/ts/api"
+	"github.com/urfave/cli"
+)
+
+func getAuditLogs(c *cli.Context) {
+	from, until, errs := parseTimeRange(c)
+	if len(errs) > 0 {
+		failUsage(c, "Unable to query audit logs.", errs...)
+	}
+
+	params := tsapi.AuditLogParams{
+		From:  from,
+		Until: until,
+	}
+	var records []tsapi.AuditRecord
+	var err error
+	if c.Bool("offline") {
+		records, err = cachedAuditLogs(c, params)
+	} else {
+		records, err = queryAuditLogs(c, params)
+	}
+	if err != nil {
+		flushPartial(c, err, records, len(records))
+	}
+
+	printJSON(c, records)
+}
+
+// queryAuditLogs - follow pagination tokens for an audit log query and return every record
+func queryAuditLogs(c *cli.Context, params tsapi.AuditLogParams) ([]tsapi.AuditRecord, error) {
+	var records []tsapi.AuditRecord
+	for {
+		var response tsapi.AuditResponseRaw
+		auditEndpoint := params.Endpoint()
+		req, err := tsBuildHTTPReq(c, "GET", auditEndpoint, nil)
+		if err != nil {
+			return records, err
+		}
+		resp, err := apiClient.Do(req)
+		if err != nil {
+			return records, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return records, err
+		}
+		if resp.StatusCode != 200 {
+			return records, tsapi.NewAPIError(resp.StatusCode, auditEndpoint, body)
+		}
+		if err := json.Unmarshal(body, &response); err != nil {
+			return records, err
+		}
+
+		records = append(records, response.Recs...)
+
+		if response.Token == "" {
+			return records, nil
+		}
+		params.Token = response.Token
+	}
+}
@@ -0,0 +1,358 @@
+// ts - golang ts api client
+// auth.go: stored credentials, credential helpers and `ts auth`
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	tsapi "github.com/threatstack/ts/api"
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// keyFileIterations is the PBKDF2 work factor for new key files. Each file
+// records its own, so this can go up without breaking old ones.
+const keyFileIterations = 600000
+
+// credentials are what requests are signed with, and where the key came from
+type credentials struct {
+	tsapi.Config
+	Source string
+}
+
+// keyFile is what `ts auth login` writes. The API key is either encrypted
+// with a key derived from a passphrase, or left to a credential helper.
+type keyFile struct {
+	Version    int    `json:"version"`
+	User       string `json:"user"`
+	Org        string `json:"org"`
+	Helper     string `json:"helper,omitempty"`
+	KDF        string `json:"kdf,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	Salt       []byte `json:"salt,omitempty"`
+	Nonce      []byte `json:"nonce,omitempty"`
+	Key        []byte `json:"key,omitempty"`
+}
+
+// Credentials are looked up once per run; a key file's passphrase is only
+// asked for the first time a request needs it.
+var (
+	credentialsMu     sync.Mutex
+	loadedCredentials *credentials
+)
+
+// loadCredentials - the credentials to sign requests with. --key (or
+// TS_API_KEY) wins, then a credential helper, then a key file. --user and
+// --org always override what a helper or key file says.
+func loadCredentials(c *cli.Context) (credentials, error) {
+	credentialsMu.Lock()
+	defer credentialsMu.Unlock()
+	if loadedCredentials != nil {
+		return *loadedCredentials, nil
+	}
+
+	var creds credentials
+	var err error
+	switch {
+	case c.GlobalString("key") != "":
+		creds.Key = c.GlobalString("key")
+		creds.Source = "--key or TS_API_KEY"
+	case c.GlobalString("credential-helper") != "":
+		creds, err = runCredentialHelper(c.GlobalString("credential-helper"))
+	default:
+		creds, err = readKeyFile(c)
+	}
+	if err != nil {
+		return creds, err
+	}
+	if c.GlobalString("user") != "" {
+		creds.User = c.GlobalString("user")
+	}
+	if c.GlobalString("org") != "" {
+		creds.Org = c.GlobalString("org")
+	}
+	if creds.Key == "" {
+		return creds, &cliError{code: exitAuth, message: "No API key: set TS_API_KEY, or run `ts auth login` to store one"}
+	}
+
+	loadedCredentials = &creds
+	return creds, nil
+}
+
+// keyFilePath - --key-file, or credentials.json in the user's config directory
+func keyFilePath(c *cli.Context) (string, error) {
+	if c.GlobalString("key-file") != "" {
+		return c.GlobalString("key-file"), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ts", "credentials.json"), nil
+}
+
+// readKeyFile - credentials from a file written by `ts auth login`, or from
+// a file holding nothing but the API key. A missing default key file just
+// means there are no stored credentials.
+func readKeyFile(c *cli.Context) (credentials, error) {
+	var creds credentials
+	path, err := keyFilePath(c)
+	if err != nil {
+		return creds, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && c.GlobalString("key-file") == "" {
+		return creds, nil
+	} else if err != nil {
+		return creds, fmt.Errorf("Unable to read key file: %w", err)
+	}
+	if info, err := os.Stat(path); err == nil && runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %s can be read by other users; run `chmod 600 %s`\n", path, path)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil || file.Version == 0 {
+		creds.Key = strings.TrimSpace(string(data))
+		creds.Source = "key file " + path
+		return creds, nil
+	}
+
+	if file.Helper != "" {
+		creds, err = runCredentialHelper(file.Helper)
+		if err != nil {
+			return creds, err
+		}
+	} else {
+		passphrase := os.Getenv("TS_KEY_PASSPHRASE")
+		if passphrase == "" {
+			passphrase, err = readSecret(fmt.Sprintf("Passphrase for %s", path))
+			if err != nil {
+				return creds, err
+			}
+		}
+		key, err := decryptKeyFile(file, passphrase)
+		if err != nil {
+			return creds, err
+		}
+		creds.Key = key
+		creds.Source = "key file " + path + " (encrypted)"
+	}
+	if creds.User == "" {
+		creds.User = file.User
+	}
+	if creds.Org == "" {
+		creds.Org = file.Org
+	}
+	return creds, nil
+}
+
+// runCredentialHelper - run "HELPER get" through the shell and read
+// key=value lines (user, org and key) from its output, like a git
+// credential helper. Anything else it prints is ignored.
+func runCredentialHelper(helper string) (credentials, error) {
+	creds := credentials{Source: "credential helper " + helper}
+	cmd := exec.Command("sh", "-c", helper+" get")
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", helper+" get")
+	}
+	// Helpers may need to ask for a password of their own
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return creds, fmt.Errorf("Credential helper %q failed: %w", helper, err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch strings.TrimSpace(parts[0]) {
+		case "user":
+			creds.User = strings.TrimSpace(parts[1])
+		case "org":
+			creds.Org = strings.TrimSpace(parts[1])
+		case "key":
+			creds.Key = strings.TrimSpace(parts[1])
+		}
+	}
+	if creds.Key == "" {
+		return creds, fmt.Errorf("Credential helper %q didn't print a key=... line", helper)
+	}
+	return creds, nil
+}
+
+// encryptKeyFile - seal the API key with AES-256-GCM, using a key derived
+// from the passphrase. The user and org IDs are authenticated too, so they
+// can't be swapped out from under the key.
+func encryptKeyFile(file *keyFile, apiKey string, passphrase string) error {
+	file.KDF = "pbkdf2-sha256"
+	file.Iterations = keyFileIterations
+	file.Salt = make([]byte, 16)
+	if _, err := rand.Read(file.Salt); err != nil {
+		return err
+	}
+	gcm, err := keyFileCipher(*file, passphrase)
+	if err != nil {
+		return err
+	}
+	file.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+	file.Key = gcm.Seal(nil, file.Nonce, []byte(apiKey), keyFileAAD(*file))
+	return nil
+}
+
+func decryptKeyFile(file keyFile, passphrase string) (string, error) {
+	if file.KDF != "pbkdf2-sha256" {
+		return "", fmt.Errorf("Unsupported key file (kdf %q); run `ts auth login` again", file.KDF)
+	}
+	gcm, err := keyFileCipher(file, passphrase)
+	if err != nil {
+		return "", err
+	}
+	if len(file.Nonce) != gcm.NonceSize() {
+		return "", errors.New("The key file is damaged; run `ts auth login` again")
+	}
+	key, err := gcm.Open(nil, file.Nonce, file.Key, keyFileAAD(file))
+	if err != nil {
+		return "", &cliError{code: exitAuth, message: "Unable to decrypt the key file: wrong passphrase, or the file was changed"}
+	}
+	return string(key), nil
+}
+
+func keyFileCipher(file keyFile, passphrase string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(passphrase), file.Salt, file.Iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func keyFileAAD(file keyFile) []byte {
+	return []byte(fmt.Sprintf("ts-key-file:%d:%s:%s", file.Version, file.User, file.Org))
+}
+
+func authLogin(c *cli.Context) {
+	path, err := keyFilePath(c)
+	if err != nil {
+		fail(c, err)
+	}
+
+	file := keyFile{Version: 1, User: c.GlobalString("user"), Org: c.GlobalString("org")}
+	if file.User == "" {
+		if file.User, err = readLine("User ID"); err != nil {
+			fail(c, err)
+		}
+	}
+	if file.Org == "" {
+		if file.Org, err = readLine("Organization ID"); err != nil {
+			fail(c, err)
+		}
+	}
+
+	var creds credentials
+	if c.String("helper") != "" {
+		file.Helper = c.String("helper")
+		if creds, err = runCredentialHelper(file.Helper); err != nil {
+			fail(c, err)
+		}
+	} else {
+		creds.Key = c.GlobalString("key")
+		if creds.Key == "" {
+			if creds.Key, err = readSecret("API key"); err != nil {
+				fail(c, err)
+			}
+		}
+		passphrase := os.Getenv("TS_KEY_PASSPHRASE")
+		if passphrase == "" {
+			if passphrase, err = readSecret("Passphrase to encrypt the key with"); err != nil {
+				fail(c, err)
+			}
+			again, err := readSecret("Passphrase again")
+			if err != nil {
+				fail(c, err)
+			}
+			if again != passphrase {
+				failUsage(c, "The passphrases don't match.")
+			}
+		}
+		if passphrase == "" || creds.Key == "" {
+			failUsage(c, "Unable to save credentials.", "The API key and passphrase can't be empty")
+		}
+		if err := encryptKeyFile(&file, creds.Key, passphrase); err != nil {
+			fail(c, err)
+		}
+	}
+	if creds.User == "" {
+		creds.User = file.User
+	}
+	if creds.Org == "" {
+		creds.Org = file.Org
+	}
+
+	// Check the credentials work before saving them
+	credentialsMu.Lock()
+	loadedCredentials = &creds
+	credentialsMu.Unlock()
+	if _, err := queryMembers(c); err != nil {
+		fail(c, fmt.Errorf("Not saving credentials, the API rejected them. %w", err))
+	}
+
+	ser, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		fail(c, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		fail(c, err)
+	}
+	if err := ioutil.WriteFile(path, ser, 0600); err != nil {
+		fail(c, err)
+	}
+	fmt.Printf("Credentials saved to %s\n", path)
+}
+
+func authStatus(c *cli.Context) {
+	creds, err := loadCredentials(c)
+	if err != nil {
+		fail(c, err)
+	}
+	fmt.Printf("Credentials:  %s\n", creds.Source)
+	fmt.Printf("User ID:      %s\n", creds.User)
+	fmt.Printf("Organization: %s\n", creds.Org)
+	fmt.Printf("Endpoint:     %s\n", c.GlobalString("endpoint"))
+
+	// Listing members is cheap, and tells us who the key belongs to
+	members, err := queryMembers(c)
+	if err != nil {
+		fail(c, err)
+	}
+	for _, member := range members {
+		if member.ID == creds.User {
+			fmt.Printf("Signed in as: %s (%s)\n", member.Email, member.Role)
+			return
+		}
+	}
+	fmt.Printf("Signed in:    yes\n")
+}
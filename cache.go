@@ -0,0 +1,573 @@
+// ts - golang ts api client
+// cache.go: local SQLite mirror of the API for offline queries
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	tsapi "github.com/threatstack/ts/api"
+	"github.com/urfave/cli"
+	_ "modernc.org/sqlite"
+)
+
+// cacheSchema keeps the fields people filter and join on as columns, and
+// the full API object in a json column so nothing is lost.
+const cacheSchema = `
+CREATE TABLE IF NOT EXISTS agents (
+	id               TEXT PRIMARY KEY,
+	status           TEXT,
+	hostname         TEXT,
+	name             TEXT,
+	version          TEXT,
+	os_version       TEXT,
+	kernel           TEXT,
+	agent_type       TEXT,
+	created_at       TEXT,
+	last_reported_at TEXT,
+	json             TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS alerts (
+	id             TEXT PRIMARY KEY,
+	title          TEXT,
+	data_source    TEXT,
+	created_at     TEXT,
+	is_dismissed   INTEGER,
+	dismissed_at   TEXT,
+	dismiss_reason TEXT,
+	dismissed_by   TEXT,
+	severity       INTEGER,
+	agent_id       TEXT,
+	rule_id        TEXT,
+	ruleset_id     TEXT,
+	json           TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS alerts_created_at ON alerts (created_at);
+CREATE INDEX IF NOT EXISTS alerts_agent_id ON alerts (agent_id);
+CREATE TABLE IF NOT EXISTS members (
+	id                    TEXT PRIMARY KEY,
+	email                 TEXT,
+	display_name          TEXT,
+	role                  TEXT,
+	mfa_enabled           INTEGER,
+	sso_enabled           INTEGER,
+	user_enabled          INTEGER,
+	last_authenticated_at INTEGER,
+	json                  TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS audit_logs (
+	id          TEXT PRIMARY KEY,
+	event_time  TEXT,
+	user_email  TEXT,
+	user_id     TEXT,
+	action      TEXT,
+	crud        TEXT,
+	result      TEXT,
+	source      TEXT,
+	description TEXT,
+	json        TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS audit_logs_event_time ON audit_logs (event_time);
+CREATE TABLE IF NOT EXISTS sync_state (
+	resource  TEXT PRIMARY KEY,
+	watermark TEXT,
+	synced_at TEXT
+);
+`
+
+// cachePath - the --cache flag, or a file under the user's cache directory
+func cachePath(c *cli.Context) (string, error) {
+	if c.GlobalString("cache") != "" {
+		return c.GlobalString("cache"), nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ts", "cache.db"), nil
+}
+
+// openCache - open the cache database. Read-only opens fail if nobody has
+// run `ts sync` yet, rather than quietly creating an empty cache.
+func openCache(c *cli.Context, readOnly bool) (*sql.DB, error) {
+	path, err := cachePath(c)
+	if err != nil {
+		return nil, err
+	}
+	if readOnly {
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("no local cache at %s - run `ts sync` first", path)
+		}
+		return sql.Open("sqlite", "file:"+path+"?mode=ro")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(cacheSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func syncCache(c *cli.Context) {
+	_, _, errs := parseTimeRange(c)
+	if len(errs) > 0 {
+		failUsage(c, "Unable to sync.", errs...)
+	}
+
+	db, err := openCache(c, false)
+	if err != nil {
+		fail(c, err)
+	}
+	defer db.Close()
+
+	steps := []struct {
+		name string
+		sync func(*cli.Context, *sql.DB) (int, error)
+	}{
+		{"agents", syncCachedAgents},
+		{"alerts", syncCachedAlerts},
+		{"members", syncCachedMembers},
+		{"audit_logs", syncCachedAuditLogs},
+	}
+	failed := false
+	for _, step := range steps {
+		n, err := step.sync(c, db)
+		if interrupted(err) {
+			// Each step commits on its own, so the ones that finished
+			// are kept and the rest will catch up next time.
+			fail(c, err)
+		}
+		if err != nil {
+			fmt.Printf("Unable to sync %s: %s\n", step.name, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("Synced %d %s\n", n, step.name)
+	}
+	if failed {
+		os.Exit(exitError)
+	}
+}
+
+// cacheWatermark - where the last sync of a resource got to. Before the
+// first sync, fall back to --from (or everything, if that isn't set).
+func cacheWatermark(c *cli.Context, db *sql.DB, resource string) (string, error) {
+	var watermark sql.NullString
+	err := db.QueryRow("SELECT watermark FROM sync_state WHERE resource = ?", resource).Scan(&watermark)
+	if err == sql.ErrNoRows || (err == nil && watermark.String == "") {
+		from, _, _ := parseTimeRange(c)
+		return from, nil
+	}
+	return watermark.String, err
+}
+
+func setCacheWatermark(tx *sql.Tx, resource string, watermark string) error {
+	_, err := tx.Exec(`INSERT INTO sync_state (resource, watermark, synced_at) VALUES (?, ?, ?)
+		ON CONFLICT (resource) DO UPDATE SET watermark = excluded.watermark, synced_at = excluded.synced_at`,
+		resource, watermark, formatAPITime(time.Now()))
+	return err
+}
+
+// syncCachedAgents - agents change state, so always replace them all
+func syncCachedAgents(c *cli.Context, db *sql.DB) (int, error) {
+	var agents []tsapi.Agent
+	for _, status := range []string{"online", "offline"} {
+		found, err := queryAgents(c, tsapi.AgentListParams{Status: status})
+		if err != nil {
+			return 0, err
+		}
+		agents = append(agents, found...)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM agents"); err != nil {
+		return 0, err
+	}
+	for _, agent := range agents {
+		ser, err := json.Marshal(agent)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(`INSERT OR REPLACE INTO agents
+			(id, status, hostname, name, version, os_version, kernel, agent_type, created_at, last_reported_at, json)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			agent.ID, agent.Status, agent.Hostname, agent.Name, agent.Version, agent.OSVersion,
+			agent.Kernel, agent.AgentType, agent.CreatedAt, agent.LastReportedAt, string(ser))
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err := setCacheWatermark(tx, "agents", ""); err != nil {
+		return 0, err
+	}
+	return len(agents), tx.Commit()
+}
+
+// syncCachedAlerts - fetch alerts created since the watermark. Alerts we
+// cached as active may have been dismissed since, so dismissed alerts are
+// fetched back to the oldest one we still think is active.
+func syncCachedAlerts(c *cli.Context, db *sql.DB) (int, error) {
+	watermark, err := cacheWatermark(c, db, "alerts")
+	if err != nil {
+		return 0, err
+	}
+
+	dismissedFrom := watermark
+	var oldestActive sql.NullString
+	if err := db.QueryRow("SELECT MIN(created_at) FROM alerts WHERE is_dismissed = 0").Scan(&oldestActive); err != nil {
+		return 0, err
+	}
+	if oldestActive.Valid {
+		if t, err := time.Parse(time.RFC3339, oldestActive.String); err == nil {
+			if w, err := time.Parse(time.RFC3339, watermark); err != nil || t.Before(w) {
+				dismissedFrom = formatAPITime(t)
+			}
+		}
+	}
+
+	active, err := queryAlerts(c, tsapi.AlertListParams{Status: "active", From: watermark})
+	if err != nil {
+		return 0, err
+	}
+	dismissed, err := queryAlerts(c, tsapi.AlertListParams{Status: "dismissed", From: dismissedFrom})
+	if err != nil {
+		return 0, err
+	}
+	alerts := append(active, dismissed...)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	latest, _ := time.Parse(time.RFC3339, watermark)
+	for _, alert := range alerts {
+		ser, err := json.Marshal(alert)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(`INSERT OR REPLACE INTO alerts
+			(id, title, data_source, created_at, is_dismissed, dismissed_at, dismiss_reason, dismissed_by,
+			 severity, agent_id, rule_id, ruleset_id, json)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			alert.ID, alert.Title, alert.DataSource, alert.CreatedAt, alert.IsDismissed, alert.DismissedAt,
+			string(alert.DismissReason), alert.DismissedBy, alert.Severity, alert.AgentID, alert.RuleID,
+			alert.RulesetID, string(ser))
+		if err != nil {
+			return 0, err
+		}
+		if createdAt := alertCreatedAt(alert); createdAt.After(latest) {
+			latest = createdAt
+		}
+	}
+	if !latest.IsZero() {
+		watermark = formatAPITime(latest)
+	}
+	if err := setCacheWatermark(tx, "alerts", watermark); err != nil {
+		return 0, err
+	}
+	return len(alerts), tx.Commit()
+}
+
+// syncCachedMembers - the members endpoint isn't paginated, so replace them all
+func syncCachedMembers(c *cli.Context, db *sql.DB) (int, error) {
+	members, err := queryMembers(c)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM members"); err != nil {
+		return 0, err
+	}
+	for _, member := range members {
+		ser, err := json.Marshal(member)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(`INSERT OR REPLACE INTO members
+			(id, email, display_name, role, mfa_enabled, sso_enabled, user_enabled, last_authenticated_at, json)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			member.ID, member.Email, member.DisplayName, member.Role, member.MFAEnabled, member.SSOEnabled,
+			member.UserEnabled, member.LastAuthenticatedAt, string(ser))
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err := setCacheWatermark(tx, "members", ""); err != nil {
+		return 0, err
+	}
+	return len(members), tx.Commit()
+}
+
+// syncCachedAuditLogs - audit records never change, so only fetch new ones
+func syncCachedAuditLogs(c *cli.Context, db *sql.DB) (int, error) {
+	watermark, err := cacheWatermark(c, db, "audit_logs")
+	if err != nil {
+		return 0, err
+	}
+	records, err := queryAuditLogs(c, tsapi.AuditLogParams{From: watermark})
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	latest, _ := time.Parse(time.RFC3339, watermark)
+	for _, record := range records {
+		ser, err := json.Marshal(record)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(`INSERT OR REPLACE INTO audit_logs
+			(id, event_time, user_email, user_id, action, crud, result, source, description, json)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			record.ID, record.EventTime, record.UserEmail, record.UserID, record.Action, record.CRUD,
+			record.Result, record.Source, record.Description, string(ser))
+		if err != nil {
+			return 0, err
+		}
+		if t, err := time.Parse(time.RFC3339, record.EventTime); err == nil && t.After(latest) {
+			latest = t
+		}
+	}
+	if !latest.IsZero() {
+		watermark = formatAPITime(latest)
+	}
+	if err := setCacheWatermark(tx, "audit_logs", watermark); err != nil {
+		return 0, err
+	}
+	return len(records), tx.Commit()
+}
+
+// loadCachedJSON - decode the json column of every row a query returns
+func loadCachedJSON(c *cli.Context, query string, args []interface{}, decode func([]byte) error) error {
+	db, err := openCache(c, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return err
+		}
+		if err := decode([]byte(raw)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// cachedAgents - agents from the cache with the given status
+func cachedAgents(c *cli.Context, params tsapi.AgentListParams) ([]tsapi.Agent, error) {
+	var agents []tsapi.Agent
+	err := loadCachedJSON(c, "SELECT json FROM agents WHERE status = ? ORDER BY id", []interface{}{params.Status}, func(raw []byte) error {
+		var agent tsapi.Agent
+		err := json.Unmarshal(raw, &agent)
+		agents = append(agents, agent)
+		return err
+	})
+	return agents, err
+}
+
+// cachedAlerts - alerts from the cache, filtered the way the API would
+func cachedAlerts(c *cli.Context, params tsapi.AlertListParams) ([]tsapi.Alert, error) {
+	var where []string
+	var args []interface{}
+	if params.Status != "" {
+		where = append(where, "is_dismissed = ?")
+		args = append(args, params.Status == "dismissed")
+	}
+	if params.Severity != 0 {
+		where = append(where, "severity = ?")
+		args = append(args, params.Severity)
+	}
+	if params.RuleID != "" {
+		where = append(where, "rule_id = ?")
+		args = append(args, params.RuleID)
+	}
+	if params.AgentID != "" {
+		where = append(where, "agent_id = ?")
+		args = append(args, params.AgentID)
+	}
+	query := "SELECT json FROM alerts"
+	if len(where) > 0 {
+		query = query + " WHERE " + strings.Join(where, " AND ")
+	}
+	query = query + " ORDER BY created_at"
+
+	from, _ := time.Parse(time.RFC3339, params.From)
+	until, _ := time.Parse(time.RFC3339, params.Until)
+	var alerts []tsapi.Alert
+	err := loadCachedJSON(c, query, args, func(raw []byte) error {
+		var alert tsapi.Alert
+		if err := json.Unmarshal(raw, &alert); err != nil {
+			return err
+		}
+		createdAt := alertCreatedAt(alert)
+		if (!from.IsZero() && createdAt.Before(from)) || (!until.IsZero() && createdAt.After(until)) {
+			return nil
+		}
+		alerts = append(alerts, alert)
+		return nil
+	})
+	return alerts, err
+}
+
+// cachedMembers - every member in the cache
+func cachedMembers(c *cli.Context) ([]tsapi.Member, error) {
+	var members []tsapi.Member
+	err := loadCachedJSON(c, "SELECT json FROM members ORDER BY email", nil, func(raw []byte) error {
+		var member tsapi.Member
+		err := json.Unmarshal(raw, &member)
+		members = append(members, member)
+		return err
+	})
+	return members, err
+}
+
+// cachedAuditLogs - audit records from the cache in a time range
+func cachedAuditLogs(c *cli.Context, params tsapi.AuditLogParams) ([]tsapi.AuditRecord, error) {
+	from, _ := time.Parse(time.RFC3339, params.From)
+	until, _ := time.Parse(time.RFC3339, params.Until)
+	var records []tsapi.AuditRecord
+	err := loadCachedJSON(c, "SELECT json FROM audit_logs ORDER BY event_time", nil, func(raw []byte) error {
+		var record tsapi.AuditRecord
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return err
+		}
+		eventTime, _ := time.Parse(time.RFC3339, record.EventTime)
+		if (!from.IsZero() && eventTime.Before(from)) || (!until.IsZero() && eventTime.After(until)) {
+			return nil
+		}
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+// queryCacheSQL - run ad-hoc read-only SQL against the cache
+func queryCacheSQL(c *cli.Context) {
+	if c.Args().Get(0) == "" {
+		failUsage(c, "Specify the SQL query as an argument.")
+	}
+	format := c.String("format")
+	if format != "table" && format != "csv" && format != "json" {
+		failUsage(c, fmt.Sprintf("Invalid format: %s (choose table, csv, or json)", format))
+	}
+
+	db, err := openCache(c, true)
+	if err != nil {
+		fail(c, err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(c.Args().Get(0))
+	if err != nil {
+		fail(c, fmt.Errorf("Unable to run query: %w", err))
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		fail(c, err)
+	}
+
+	var results [][]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			fail(c, err)
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		results = append(results, values)
+	}
+	if err := rows.Err(); err != nil {
+		fail(c, fmt.Errorf("Unable to run query: %w", err))
+	}
+
+	switch format {
+	case "json":
+		objects := make([]map[string]interface{}, 0, len(results))
+		for _, row := range results {
+			object := make(map[string]interface{})
+			for i, column := range columns {
+				object[column] = row[i]
+			}
+			objects = append(objects, object)
+		}
+		ser, err := json.Marshal(objects)
+		if err != nil {
+			fail(c, err)
+		}
+		fmt.Println(string(ser))
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		w.Write(columns)
+		for _, row := range results {
+			w.Write(sqlRowStrings(row))
+		}
+		w.Flush()
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.ToUpper(strings.Join(columns, "\t")))
+		for _, row := range results {
+			fmt.Fprintln(w, strings.Join(sqlRowStrings(row), "\t"))
+		}
+		w.Flush()
+	}
+}
+
+func sqlRowStrings(row []interface{}) []string {
+	out := make([]string, len(row))
+	for i, v := range row {
+		if v == nil {
+			out[i] = ""
+		} else {
+			out[i] = fmt.Sprintf("%v", v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,108 @@
+// ts - golang ts api client
+// cancel.go: --timeout and Ctrl-C handling for API requests
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// appContext is cancelled by the first Ctrl-C. Every API request is made
+// with it, so an interrupt aborts whatever is in flight.
+var appContext = context.Background()
+
+// handleInterrupts - cancel appContext on the first Ctrl-C so commands can
+// stop and flush what they have. A second Ctrl-C exits straight away, for
+// anything that isn't waiting on the API.
+func handleInterrupts() {
+	ctx, cancel := context.WithCancel(context.Background())
+	appContext = ctx
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		cancel()
+		<-signals
+		os.Exit(exitInterrupted)
+	}()
+}
+
+// interrupted - whether err came from a request Ctrl-C cancelled
+func interrupted(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
+
+// pause - sleep for d, returning false early on Ctrl-C
+func pause(d time.Duration) bool {
+	select {
+	case <-appContext.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// flushPartial - report a paginated query's error. If Ctrl-C cut it short,
+// print what was collected first, and flag the output as incomplete.
+func flushPartial(c *cli.Context, err error, results interface{}, count int) {
+	if !interrupted(err) || count == 0 {
+		fail(c, err)
+	}
+	printJSON(c, results)
+	fail(c, incompleteOutput())
+}
+
+// incompleteOutput - the error for a query Ctrl-C cut short after some of
+// its results were printed
+func incompleteOutput() error {
+	return &cliError{
+		code:       exitInterrupted,
+		message:    "Interrupted: output is incomplete, only what was fetched before Ctrl-C was printed",
+		incomplete: true,
+	}
+}
+
+// deadlineTransport gives each request --timeout to finish, including
+// reading the response body
+type deadlineTransport struct {
+	next    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("no response within %s (raise --timeout for slow queries): %w", t.timeout, err)
+		}
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases a request's deadline once its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,142 @@
+// ts - golang ts api client
+// errors.go: exit codes and error reporting
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	tsapi "github.com/threatstack/ts/api"
+	"github.com/urfave/cli"
+)
+
+// Exit codes. These are part of the CLI's interface (see README.md), so
+// don't renumber them.
+const (
+	exitOK          = 0
+	exitError       = 1   // anything not covered below: network failures, local files, aborted prompts
+	exitUsage       = 2   // bad flags or arguments, or the API rejected the request (400, 422)
+	exitAuth        = 3   // the API rejected the credentials (401, 403)
+	exitNotFound    = 4   // the API or a lookup found nothing (404)
+	exitRateLimited = 5   // the API is rate limiting us (429)
+	exitServer      = 6   // the API failed (5xx)
+	exitFindings    = 7   // a check ran and found problems (members audit, --check)
+	exitInterrupted = 130 // Ctrl-C
+)
+
+// cliError is an error that isn't from the API, with the exit code it
+// should produce
+type cliError struct {
+	code       int
+	message    string
+	details    []string
+	incomplete bool // partial results were printed before the error
+}
+
+func (e *cliError) Error() string {
+	msg := e.message
+	for _, v := range e.details {
+		msg = msg + fmt.Sprintf("\n         * %s", v)
+	}
+	return msg
+}
+
+// notFound - an error for a lookup that found nothing
+func notFound(format string, args ...interface{}) error {
+	return &cliError{code: exitNotFound, message: fmt.Sprintf(format, args...)}
+}
+
+// exitCode - the exit code an error should produce
+func exitCode(err error) int {
+	var apiErr *tsapi.APIError
+	var cliErr *cliError
+	switch {
+	case err == nil:
+		return exitOK
+	case interrupted(err):
+		return exitInterrupted
+	case errors.As(err, &cliErr):
+		return cliErr.code
+	case errors.As(err, &apiErr):
+		switch {
+		case apiErr.StatusCode == 401 || apiErr.StatusCode == 403:
+			return exitAuth
+		case apiErr.StatusCode == 404:
+			return exitNotFound
+		case apiErr.StatusCode == 429:
+			return exitRateLimited
+		case apiErr.StatusCode >= 500:
+			return exitServer
+		case apiErr.StatusCode >= 400:
+			return exitUsage
+		}
+	}
+	return exitError
+}
+
+// errorOutput is what --output json writes to stderr
+type errorOutput struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Message    string   `json:"message"`
+	ExitCode   int      `json:"exitCode"`
+	Status     int      `json:"status,omitempty"`
+	Endpoint   string   `json:"endpoint,omitempty"`
+	Errors     []string `json:"errors,omitempty"`
+	Details    []string `json:"details,omitempty"`
+	Incomplete bool     `json:"incomplete,omitempty"`
+}
+
+// fail - report an error and exit with its exit code. With --output json
+// the error goes to stderr as a JSON object instead.
+func fail(c *cli.Context, err error) {
+	code := exitCode(err)
+	var apiErr *tsapi.APIError
+	var cliErr *cliError
+	if code == exitInterrupted && !errors.As(err, &cliErr) {
+		// "context canceled" from deep in net/http isn't much help
+		err = &cliError{code: exitInterrupted, message: "Interrupted"}
+	}
+	if c.GlobalString("output") != "json" {
+		// Keep the notice out of any partial results on stdout
+		if errors.As(err, &cliErr) && cliErr.incomplete {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		} else {
+			fmt.Printf("%s\n", err)
+		}
+		os.Exit(code)
+	}
+
+	detail := errorDetail{Message: err.Error(), ExitCode: code}
+	if errors.As(err, &apiErr) {
+		detail.Status = apiErr.StatusCode
+		detail.Endpoint = apiErr.Endpoint
+		detail.Errors = apiErr.Errors
+	}
+	if errors.As(err, &cliErr) {
+		detail.Message = cliErr.message
+		detail.Details = cliErr.details
+		detail.Incomplete = cliErr.incomplete
+	}
+	ser, _ := json.Marshal(errorOutput{Error: detail})
+	fmt.Fprintf(os.Stderr, "%s\n", ser)
+	os.Exit(code)
+}
+
+// failUsage - show the command's help and fail with a usage error
+func failUsage(c *cli.Context, message string, details ...string) {
+	if c.GlobalString("output") != "json" {
+		cli.ShowSubcommandHelp(c)
+		fmt.Printf("\n")
+		message = "ERROR: " + message
+	}
+	fail(c, &cliError{code: exitUsage, message: message, details: details})
+}
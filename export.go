@@ -0,0 +1,247 @@
+// ts - golang ts api client
+// export.go: bulk export of alerts and their events for forensics
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	tsapi "github.com/threatstack/ts/api"
+	"github.com/urfave/cli"
+)
+
+// exportManifest describes an evidence package written by `ts alerts export`
+type exportManifest struct {
+	CreatedAt      string               `json:"createdAt"`
+	OrganizationID string               `json:"organizationId"`
+	From           string               `json:"from,omitempty"`
+	Until          string               `json:"until,omitempty"`
+	WithEvents     bool                 `json:"withEvents"`
+	Alerts         int                  `json:"alerts"`
+	Files          []exportManifestFile `json:"files"`
+	Errors         []string             `json:"errors,omitempty"`
+	Incomplete     bool                 `json:"incomplete,omitempty"` // interrupted before every alert was written
+}
+
+// exportManifestFile is the manifest entry for one alert bundle
+type exportManifestFile struct {
+	AlertID string `json:"alertId"`
+	File    string `json:"file"`
+	SHA256  string `json:"sha256"`
+	Bytes   int64  `json:"bytes"`
+	Events  int    `json:"events"`
+}
+
+// exportRecord is a single line in an alert bundle
+type exportRecord struct {
+	Type  string            `json:"type"`
+	Alert *tsapi.Alert      `json:"alert,omitempty"`
+	Event *tsapi.AlertEvent `json:"event,omitempty"`
+}
+
+// unsafeFilename matches anything we don't want in a bundle file name
+var unsafeFilename = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+func exportAlerts(c *cli.Context) {
+	validInput := true
+	var errs []string
+
+	if c.String("out") == "" {
+		errs = append(errs, "Missing output directory")
+		validInput = false
+	}
+
+	if c.Int("concurrency") < 1 {
+		errs = append(errs, "Concurrency must be at least 1")
+		validInput = false
+	}
+
+	from, until, timeErrs := parseTimeRange(c)
+	if len(timeErrs) > 0 {
+		errs = append(errs, timeErrs...)
+		validInput = false
+	}
+
+	statuses, err := parseAlertStatuses(c.String("status"))
+	if err != nil {
+		errs = append(errs, err.Error())
+		validInput = false
+	}
+
+	if !validInput {
+		failUsage(c, "Unable to export alerts.", errs...)
+	}
+
+	outDir := c.String("out")
+	if err := os.MkdirAll(outDir, 0700); err != nil {
+		fail(c, err)
+	}
+
+	var alerts []tsapi.Alert
+	for _, status := range statuses {
+		params := tsapi.AlertListParams{
+			Status: status,
+			From:   from,
+			Until:  until,
+		}
+		alerts = append(alerts, fetchAlerts(c, params)...)
+	}
+	creds, err := loadCredentials(c)
+	if err != nil {
+		fail(c, err)
+	}
+
+	manifest := exportManifest{
+		CreatedAt:      time.Now().UTC().Format(time.RFC3339),
+		OrganizationID: creds.Org,
+		From:           from,
+		Until:          until,
+		WithEvents:     c.Bool("with-events"),
+		Alerts:         len(alerts),
+		Files:          make([]exportManifestFile, len(alerts)),
+	}
+
+	// Bundles are written by a small pool of workers so we don't hammer the
+	// API; each worker fills in its own slot in the manifest when its bundle
+	// is written.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sem := make(chan struct{}, c.Int("concurrency"))
+	for i := range alerts {
+		sem <- struct{}{}
+		if appContext.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			entry, err := writeAlertBundle(c, outDir, alerts[i], c.Bool("with-events"))
+			if interrupted(err) {
+				return
+			}
+			if err != nil {
+				mu.Lock()
+				manifest.Errors = append(manifest.Errors, fmt.Sprintf("%s: %s", alerts[i].ID, err))
+				mu.Unlock()
+				return
+			}
+			manifest.Files[i] = entry
+		}(i)
+	}
+	wg.Wait()
+
+	// The manifest only lists bundles that were written; failures are in
+	// Errors, and anything Ctrl-C stopped short is left out.
+	written := []exportManifestFile{}
+	for _, entry := range manifest.Files {
+		if entry.AlertID != "" {
+			written = append(written, entry)
+		}
+	}
+	manifest.Files = written
+	manifest.Incomplete = appContext.Err() != nil
+
+	ser, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		fail(c, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(outDir, "manifest.json"), ser, 0600); err != nil {
+		fail(c, err)
+	}
+
+	if manifest.Incomplete {
+		fmt.Printf("Exported %d of %d alerts to %s\n", len(manifest.Files), len(alerts), outDir)
+		fail(c, &cliError{
+			code:       exitInterrupted,
+			message:    "Interrupted: the export is incomplete, and its manifest.json says so",
+			incomplete: true,
+		})
+	}
+	fmt.Printf("Exported %d alerts to %s\n", len(manifest.Files), outDir)
+	if len(manifest.Errors) > 0 {
+		fmt.Printf("Unable to export %d alerts:\n", len(manifest.Errors))
+		for _, v := range manifest.Errors {
+			fmt.Printf("* %s\n", v)
+		}
+		os.Exit(exitError)
+	}
+}
+
+// writeAlertBundle - write one alert (and optionally its events) to a
+// gzipped NDJSON file, returning its manifest entry
+func writeAlertBundle(c *cli.Context, outDir string, alert tsapi.Alert, withEvents bool) (entry exportManifestFile, err error) {
+	entry = exportManifestFile{
+		AlertID: alert.ID,
+		File:    unsafeFilename.ReplaceAllString(alert.ID, "_") + ".ndjson.gz",
+	}
+
+	var events []tsapi.AlertEvent
+	if withEvents {
+		events, err = fetchAlertEvents(c, alert.ID)
+		if err != nil {
+			return entry, err
+		}
+	}
+
+	path := filepath.Join(outDir, entry.File)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return entry, err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		// Don't leave a partial bundle behind for someone to mistake
+		// for evidence
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	zw := gzip.NewWriter(io.MultiWriter(file, hash, counter))
+	enc := json.NewEncoder(zw)
+	if err := enc.Encode(exportRecord{Type: "alert", Alert: &alert}); err != nil {
+		return entry, err
+	}
+	for i := range events {
+		if err := enc.Encode(exportRecord{Type: "event", Event: &events[i]}); err != nil {
+			return entry, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return entry, err
+	}
+
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	entry.Bytes = counter.n
+	entry.Events = len(events)
+	return entry, nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,275 @@
+// ts - golang ts api client
+// exporter.go: expose Threat Stack posture as Prometheus metrics
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tsapi "github.com/threatstack/ts/api"
+	"github.com/urfave/cli"
+)
+
+// exporterMetrics holds everything the exporter reports. Gauges are
+// replaced on every poll; counters only ever go up.
+type exporterMetrics struct {
+	mu sync.Mutex
+
+	up          float64
+	lastSuccess float64
+
+	agents        map[[3]string]float64 // status, version, os
+	alertsActive  map[string]float64    // severity
+	alertsCreated map[string]float64    // rule
+
+	apiRequests     map[[3]string]float64 // method, endpoint, code
+	apiErrors       map[[2]string]float64 // method, endpoint
+	apiLatencySum   map[[2]string]float64
+	apiLatencyCount map[[2]string]float64
+
+	// watermark and seen track which alerts have already been counted in
+	// alertsCreated, the same way the forwarder's checkpoint does.
+	watermark time.Time
+	seen      map[string]bool
+}
+
+// instrumentedTransport records latency and errors for every API request
+type instrumentedTransport struct {
+	next    http.RoundTripper
+	metrics *exporterMetrics
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start).Seconds()
+
+	key := [2]string{req.Method, req.URL.Path}
+	t.metrics.mu.Lock()
+	defer t.metrics.mu.Unlock()
+	t.metrics.apiLatencySum[key] += elapsed
+	t.metrics.apiLatencyCount[key]++
+	if err != nil {
+		t.metrics.apiErrors[key]++
+		return resp, err
+	}
+	t.metrics.apiRequests[[3]string{req.Method, req.URL.Path, strconv.Itoa(resp.StatusCode)}]++
+	if resp.StatusCode >= 400 {
+		t.metrics.apiErrors[key]++
+	}
+	return resp, err
+}
+
+func runExporter(c *cli.Context) {
+	if c.Duration("interval") < 10*time.Second {
+		failUsage(c, "Poll interval must be at least 10s.")
+	}
+
+	metrics := &exporterMetrics{
+		agents:          make(map[[3]string]float64),
+		alertsActive:    make(map[string]float64),
+		alertsCreated:   make(map[string]float64),
+		apiRequests:     make(map[[3]string]float64),
+		apiErrors:       make(map[[2]string]float64),
+		apiLatencySum:   make(map[[2]string]float64),
+		apiLatencyCount: make(map[[2]string]float64),
+		watermark:       time.Now(),
+		seen:            make(map[string]bool),
+	}
+
+	// Instrument the API client, so every request the poller makes is counted
+	apiClient = &http.Client{Transport: &instrumentedTransport{next: apiClient.Transport, metrics: metrics}}
+
+	go func() {
+		for {
+			if err := pollExporterMetrics(c, metrics); err != nil && !interrupted(err) {
+				log.Printf("exporter: %s", err)
+			}
+			if !pause(c.Duration("interval")) {
+				return
+			}
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.write(w)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "<html><body><a href=\"/metrics\">Threat Stack metrics</a></body></html>\n")
+	})
+
+	server := &http.Server{Addr: c.String("listen"), Handler: mux}
+	go func() {
+		<-appContext.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	log.Printf("exporter: listening on %s", c.String("listen"))
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		fail(c, err)
+	}
+	fail(c, appContext.Err())
+}
+
+// pollExporterMetrics - refresh agent and alert metrics from the API
+func pollExporterMetrics(c *cli.Context, metrics *exporterMetrics) error {
+	agents := make(map[[3]string]float64)
+	for _, status := range []string{"online", "offline"} {
+		found, err := queryAgents(c, tsapi.AgentListParams{Status: status})
+		if err != nil {
+			metrics.markDown()
+			return err
+		}
+		for _, agent := range found {
+			agents[[3]string{agent.Status, agent.Version, agent.OSVersion}]++
+		}
+	}
+
+	active, err := queryAlerts(c, tsapi.AlertListParams{Status: "active"})
+	if err != nil {
+		metrics.markDown()
+		return err
+	}
+	alertsActive := make(map[string]float64)
+	for _, alert := range active {
+		alertsActive[strconv.Itoa(alert.Severity)]++
+	}
+
+	metrics.mu.Lock()
+	watermark := metrics.watermark
+	metrics.mu.Unlock()
+	var created []tsapi.Alert
+	for _, status := range []string{"active", "dismissed"} {
+		found, err := queryAlerts(c, tsapi.AlertListParams{Status: status, From: formatAPITime(watermark)})
+		if err != nil {
+			metrics.markDown()
+			return err
+		}
+		created = append(created, found...)
+	}
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	metrics.agents = agents
+	metrics.alertsActive = alertsActive
+	for _, alert := range created {
+		createdAt := alertCreatedAt(alert)
+		if metrics.seen[alert.ID] || createdAt.Before(metrics.watermark) {
+			continue
+		}
+		metrics.alertsCreated[alert.RuleID]++
+		if createdAt.After(metrics.watermark) {
+			metrics.watermark = createdAt
+			metrics.seen = make(map[string]bool)
+		}
+		metrics.seen[alert.ID] = true
+	}
+	metrics.up = 1
+	metrics.lastSuccess = float64(time.Now().Unix())
+	return nil
+}
+
+// markDown - record a failed poll, leaving the last good values in place
+func (m *exporterMetrics) markDown() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.up = 0
+}
+
+// write renders the metrics in the Prometheus text exposition format
+func (m *exporterMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeMetricHeader(w, "threatstack_up", "gauge", "Whether the last poll of the Threat Stack API succeeded.")
+	fmt.Fprintf(w, "threatstack_up %g\n", m.up)
+	writeMetricHeader(w, "threatstack_last_success_timestamp_seconds", "gauge", "Unix time of the last successful poll.")
+	fmt.Fprintf(w, "threatstack_last_success_timestamp_seconds %g\n", m.lastSuccess)
+
+	writeMetricHeader(w, "threatstack_agents", "gauge", "Number of agents by status, version and OS.")
+	for _, key := range sortedKeys3(m.agents) {
+		fmt.Fprintf(w, "threatstack_agents{status=%s,version=%s,os=%s} %g\n", quoteLabel(key[0]), quoteLabel(key[1]), quoteLabel(key[2]), m.agents[key])
+	}
+
+	writeMetricHeader(w, "threatstack_alerts_active", "gauge", "Number of active alerts by severity.")
+	for _, key := range sortedKeys1(m.alertsActive) {
+		fmt.Fprintf(w, "threatstack_alerts_active{severity=%s} %g\n", quoteLabel(key), m.alertsActive[key])
+	}
+
+	writeMetricHeader(w, "threatstack_alerts_created_total", "counter", "Alerts created since the exporter started, by rule ID.")
+	for _, key := range sortedKeys1(m.alertsCreated) {
+		fmt.Fprintf(w, "threatstack_alerts_created_total{rule=%s} %g\n", quoteLabel(key), m.alertsCreated[key])
+	}
+
+	writeMetricHeader(w, "threatstack_api_requests_total", "counter", "Threat Stack API requests by method, endpoint and HTTP status.")
+	for _, key := range sortedKeys3(m.apiRequests) {
+		fmt.Fprintf(w, "threatstack_api_requests_total{method=%s,endpoint=%s,code=%s} %g\n", quoteLabel(key[0]), quoteLabel(key[1]), quoteLabel(key[2]), m.apiRequests[key])
+	}
+
+	writeMetricHeader(w, "threatstack_api_errors_total", "counter", "Threat Stack API requests that failed or returned an HTTP error.")
+	for _, key := range sortedKeys2(m.apiErrors) {
+		fmt.Fprintf(w, "threatstack_api_errors_total{method=%s,endpoint=%s} %g\n", quoteLabel(key[0]), quoteLabel(key[1]), m.apiErrors[key])
+	}
+
+	writeMetricHeader(w, "threatstack_api_request_duration_seconds", "summary", "Threat Stack API request latency.")
+	for _, key := range sortedKeys2(m.apiLatencyCount) {
+		labels := fmt.Sprintf("method=%s,endpoint=%s", quoteLabel(key[0]), quoteLabel(key[1]))
+		fmt.Fprintf(w, "threatstack_api_request_duration_seconds_sum{%s} %g\n", labels, m.apiLatencySum[key])
+		fmt.Fprintf(w, "threatstack_api_request_duration_seconds_count{%s} %g\n", labels, m.apiLatencyCount[key])
+	}
+}
+
+func writeMetricHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// quoteLabel escapes a label value per the exposition format
+func quoteLabel(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+func sortedKeys1(m map[string]float64) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedKeys2(m map[[2]string]float64) [][2]string {
+	var keys [][2]string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return strings.Join(keys[i][:], "\x00") < strings.Join(keys[j][:], "\x00")
+	})
+	return keys
+}
+
+func sortedKeys3(m map[[3]string]float64) [][3]string {
+	var keys [][3]string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return strings.Join(keys[i][:], "\x00") < strings.Join(keys[j][:], "\x00")
+	})
+	return keys
+}
@@ -0,0 +1,233 @@
+// ts - golang ts api client
+// forward.go: continuously ship new alerts to another system
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	tsapi "github.com/threatstack/ts/api"
+	"github.com/urfave/cli"
+)
+
+// alertSink is somewhere the forwarder can send alerts
+type alertSink interface {
+	Send(alert tsapi.Alert) error
+	Close() error
+}
+
+// forwardCheckpoint records how far the forwarder has gotten, so a restart
+// picks up where it left off instead of resending or skipping alerts.
+type forwardCheckpoint struct {
+	LastCreatedAt string   `json:"lastCreatedAt"`
+	SeenIDs       []string `json:"seenIds"`
+}
+
+func forwardAlerts(c *cli.Context) {
+	validInput := true
+	var errs []string
+
+	if c.String("syslog") == "" && c.String("webhook") == "" {
+		errs = append(errs, "Missing a destination (--syslog or --webhook)")
+		validInput = false
+	}
+
+	if c.String("syslog") != "" && c.String("webhook") != "" {
+		errs = append(errs, "Choose one destination, not both --syslog and --webhook")
+		validInput = false
+	}
+
+	if c.Int("retries") < 0 {
+		errs = append(errs, "Retries can't be negative")
+		validInput = false
+	}
+
+	if c.String("checkpoint") == "" {
+		errs = append(errs, "Missing checkpoint file")
+		validInput = false
+	}
+
+	if c.Duration("interval") < time.Second {
+		errs = append(errs, "Poll interval must be at least 1s")
+		validInput = false
+	}
+
+	from, _, timeErrs := parseTimeRange(c)
+	if len(timeErrs) > 0 {
+		errs = append(errs, timeErrs...)
+		validInput = false
+	}
+
+	var sink alertSink
+	if validInput && c.String("syslog") != "" {
+		syslog, err := newSyslogSink(c.String("syslog"), c.String("format"))
+		if err != nil {
+			errs = append(errs, err.Error())
+			validInput = false
+		}
+		sink = syslog
+	}
+	if validInput && c.String("webhook") != "" {
+		webhook, err := newWebhookSink(c.String("webhook"), c.String("template"))
+		if err != nil {
+			errs = append(errs, err.Error())
+			validInput = false
+		} else {
+			webhook.secret = []byte(c.String("secret"))
+			webhook.retries = c.Int("retries")
+			webhook.deadLetter = c.String("dead-letter")
+			if c.Bool("enrich") {
+				webhook.lookupAgent = func(id string) (tsapi.Agent, error) {
+					return lookupAgent(c, id)
+				}
+			}
+		}
+		sink = webhook
+	}
+
+	if !validInput {
+		failUsage(c, "Unable to forward alerts.", errs...)
+	}
+	defer sink.Close()
+
+	checkpoint, err := loadForwardCheckpoint(c.String("checkpoint"))
+	if err != nil {
+		fail(c, err)
+	}
+	if checkpoint.LastCreatedAt == "" {
+		// Without a checkpoint, only forward alerts from here on out
+		// unless we were told where to start.
+		checkpoint.LastCreatedAt = from
+		if checkpoint.LastCreatedAt == "" {
+			checkpoint.LastCreatedAt = formatAPITime(time.Now())
+		}
+	}
+
+	for {
+		sent, err := forwardOnce(c, sink, &checkpoint)
+		if interrupted(err) {
+			// The checkpoint is saved after every alert, so the next
+			// run picks up from here.
+			fail(c, err)
+		}
+		if err != nil {
+			log.Printf("forward: %s", err)
+		}
+		if sent > 0 {
+			log.Printf("forward: sent %d alerts, checkpoint at %s", sent, checkpoint.LastCreatedAt)
+		}
+		if c.Bool("once") {
+			if err != nil {
+				os.Exit(exitError)
+			}
+			return
+		}
+		if !pause(c.Duration("interval")) {
+			fail(c, appContext.Err())
+		}
+	}
+}
+
+// forwardOnce - send every alert created since the checkpoint, oldest
+// first, saving the checkpoint after each one
+func forwardOnce(c *cli.Context, sink alertSink, checkpoint *forwardCheckpoint) (int, error) {
+	var alerts []tsapi.Alert
+	for _, status := range []string{"active", "dismissed"} {
+		params := tsapi.AlertListParams{
+			Status: status,
+			From:   checkpoint.LastCreatedAt,
+		}
+		found, err := queryAlerts(c, params)
+		if err != nil {
+			return 0, err
+		}
+		alerts = append(alerts, found...)
+	}
+
+	sort.SliceStable(alerts, func(i, j int) bool {
+		return alertCreatedAt(alerts[i]).Before(alertCreatedAt(alerts[j]))
+	})
+
+	seen := make(map[string]bool)
+	for _, id := range checkpoint.SeenIDs {
+		seen[id] = true
+	}
+
+	sent := 0
+	for _, alert := range alerts {
+		last, _ := time.Parse(time.RFC3339, checkpoint.LastCreatedAt)
+		if seen[alert.ID] || alertCreatedAt(alert).Before(last) {
+			continue
+		}
+		if err := sink.Send(alert); err != nil {
+			return sent, fmt.Errorf("unable to send alert %s: %w", alert.ID, err)
+		}
+		sent++
+		seen[alert.ID] = true
+
+		// Alerts created in the same instant as the checkpoint will come
+		// back on the next poll, so remember which ones we've sent.
+		if createdAt := alertCreatedAt(alert); createdAt.After(last) {
+			checkpoint.LastCreatedAt = formatAPITime(createdAt)
+			checkpoint.SeenIDs = nil
+		}
+		checkpoint.SeenIDs = append(checkpoint.SeenIDs, alert.ID)
+		if err := saveForwardCheckpoint(c.String("checkpoint"), *checkpoint); err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+func alertCreatedAt(alert tsapi.Alert) time.Time {
+	t, _ := time.Parse(time.RFC3339, alert.CreatedAt)
+	return t
+}
+
+func loadForwardCheckpoint(path string) (forwardCheckpoint, error) {
+	var checkpoint forwardCheckpoint
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return checkpoint, err
+	}
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return checkpoint, fmt.Errorf("unable to read checkpoint %s: %s", path, err)
+	}
+	return checkpoint, nil
+}
+
+// saveForwardCheckpoint - write the checkpoint atomically so a crash
+// mid-write can't leave us with a corrupt file
+func saveForwardCheckpoint(path string, checkpoint forwardCheckpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".checkpoint-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,187 @@
+// ts - golang ts api client
+// invites.go: pending invites and bulk invites
+//
+// Copyright 2022 F5, Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	tsapi "github.com/threatstack/ts/api"
+	"github.com/urfave/cli"
+)
+
+func getInvites(c *cli.Context) {
+	invites, err := queryInvites(c)
+	if err != nil {
+		fail(c, err)
+	}
+	printJSON(c, invites)
+}
+
+// queryInvites - fetch the organization's outstanding invites
+func queryInvites(c *cli.Context) ([]tsapi.InviteResponse, error) {
+	var invites tsapi.InvitesResponseRaw
+	inviteEndpoint := "/v2/organizations/invites"
+	req, err := tsBuildHTTPReq(c, "GET", inviteEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, tsapi.NewAPIError(resp.StatusCode, inviteEndpoint, body)
+	}
+	if err := json.Unmarshal(body, &invites); err != nil {
+		return nil, err
+	}
+	return invites.Invites, nil
+}
+
+// pendingInvites - the e-mail addresses (lowercased) with an invite that's
+// still waiting to be accepted; expired and revoked invites don't count
+func pendingInvites(invites []tsapi.InviteResponse) map[string]bool {
+	pending := make(map[string]bool)
+	for _, invite := range invites {
+		if strings.EqualFold(invite.Status, "pending") {
+			pending[strings.ToLower(invite.SentToEmail)] = true
+		}
+	}
+	return pending
+}
+
+// findInvite - look up a pending invite by ID or e-mail address
+func findInvite(c *cli.Context, idOrEmail string) (tsapi.InviteResponse, error) {
+	invites, err := queryInvites(c)
+	if err != nil {
+		return tsapi.InviteResponse{}, err
+	}
+	for _, invite := range invites {
+		if invite.ID == idOrEmail || strings.EqualFold(invite.SentToEmail, idOrEmail) {
+			return invite, nil
+		}
+	}
+	return tsapi.InviteResponse{}, notFound("No invite found with ID or e-mail %s", idOrEmail)
+}
+
+func resendInvite(c *cli.Context) {
+	invite := inviteFromArgs(c, "resend")
+	endpoint := tsapi.Path("v2", "organizations", "invites", invite.ID, "resend")
+	if err := sendInviteRequest(c, "POST", endpoint); err != nil {
+		fail(c, fmt.Errorf("Unable to resend invite. %w", err))
+	}
+	fmt.Printf("Invite resent to %s\n", invite.SentToEmail)
+}
+
+func revokeInvite(c *cli.Context) {
+	invite := inviteFromArgs(c, "revoke")
+	endpoint := tsapi.Path("v2", "organizations", "invites", invite.ID)
+	if err := sendInviteRequest(c, "DELETE", endpoint); err != nil {
+		fail(c, fmt.Errorf("Unable to revoke invite. %w", err))
+	}
+	fmt.Printf("Invite for %s revoked\n", invite.SentToEmail)
+}
+
+// inviteFromArgs - resolve the ID or e-mail argument to a pending invite
+func inviteFromArgs(c *cli.Context, action string) tsapi.InviteResponse {
+	if c.Args().Get(0) == "" {
+		failUsage(c, fmt.Sprintf("Specify the invite ID or e-mail address you want to %s as an argument.", action))
+	}
+	invite, err := findInvite(c, c.Args().Get(0))
+	if err != nil {
+		fail(c, err)
+	}
+	if invite.ID == "" {
+		fail(c, fmt.Errorf("The API didn't return an ID for the invite to %s, so it can't be changed.", invite.SentToEmail))
+	}
+	return invite
+}
+
+func sendInviteRequest(c *cli.Context, method string, endpoint string) error {
+	req, err := tsBuildHTTPReq(c, method, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 && resp.StatusCode != 204 {
+		return tsapi.NewAPIError(resp.StatusCode, endpoint, body)
+	}
+	return nil
+}
+
+// bulkInviteUsers - invite everyone in a roster file, reporting each row.
+// Existing members and people with a pending invite are skipped, and rows
+// that aren't valid fail without stopping the rest.
+func bulkInviteUsers(c *cli.Context) {
+	entries, err := readRoster(c.String("file"))
+	if err != nil {
+		fail(c, fmt.Errorf("Unable to load invite file. %w", err))
+	}
+	problems := rosterProblems(entries)
+
+	existing := make(map[string]string)
+	members, err := queryMembers(c)
+	if err != nil {
+		fail(c, err)
+	}
+	for _, member := range members {
+		existing[strings.ToLower(member.Email)] = "skipped: already a member"
+	}
+	invites, err := queryInvites(c)
+	if err != nil {
+		fail(c, err)
+	}
+	for email := range pendingInvites(invites) {
+		existing[email] = "skipped: invite already pending"
+	}
+
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "EMAIL\tROLE\tRESULT\n")
+	for i, entry := range entries {
+		result := existing[strings.ToLower(entry.Email)]
+		if problems[i] != "" {
+			result = "failed: " + problems[i]
+		} else if result == "" {
+			if entry.Role != "user" && entry.Role != "reader" {
+				result = "failed: invites can only be 'user' or 'reader'"
+			} else if _, err := sendInvite(c, tsapi.InvitePost{Email: entry.Email, Role: entry.Role}); err != nil {
+				result = "failed: " + strings.Replace(err.Error(), "\n", " ", -1)
+			} else {
+				result = "invited"
+			}
+		}
+		if strings.HasPrefix(result, "failed") {
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", entry.Email, entry.Role, result)
+	}
+	w.Flush()
+
+	if failed > 0 {
+		fmt.Printf("\n")
+		fail(c, fmt.Errorf("%d of %d invites failed.", failed, len(entries)))
+	}
+}
@@ -7,9 +7,10 @@
 package main
 
 import (
-	"log"
+	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	tsapi "github.com/threatstack/ts/api"
 	"github.com/urfave/cli"
@@ -46,9 +47,63 @@ func main() {
 			},
 			&cli.StringFlag{
 				Name:   "key, k",
-				Usage:  "API Key",
+				Usage:  "API Key (visible to other users in ps; prefer TS_API_KEY or --key-file)",
 				EnvVar: "TS_API_KEY",
 			},
+			&cli.StringFlag{
+				Name:   "key-file",
+				Usage:  "Read credentials from `FILE`, as saved by 'ts auth login' or holding just the API key (default: credentials.json in your user config directory)",
+				EnvVar: "TS_KEY_FILE",
+			},
+			&cli.StringFlag{
+				Name:   "credential-helper",
+				Usage:  "Run \"`COMMAND` get\" and read user=, org= and key= lines from its output for credentials",
+				EnvVar: "TS_CREDENTIAL_HELPER",
+			},
+			&cli.StringFlag{
+				Name:  "filter",
+				Usage: "JMESPath expression applied to JSON output, e.g. \"[?status=='online'].hostname\"",
+			},
+			&cli.StringFlag{
+				Name:  "fields",
+				Usage: "Comma-separated fields to keep in JSON output, e.g. id,hostname,tags",
+			},
+			&cli.StringFlag{
+				Name:   "cache",
+				Usage:  "Local cache database used by sync, query and --offline (default: in your user cache directory)",
+				EnvVar: "TS_CACHE",
+			},
+			&cli.BoolFlag{
+				Name:  "trace",
+				Usage: "Log every API request and response to stderr, with timings (the Authorization header is redacted)",
+			},
+			&cli.DurationFlag{
+				Name:   "timeout",
+				Usage:  "Give up on any single API request that takes longer than this (0 waits forever)",
+				Value:  time.Minute,
+				EnvVar: "TS_TIMEOUT",
+			},
+			&cli.StringFlag{
+				Name:   "output",
+				Usage:  "How errors are reported: text on stdout, or a JSON object on stderr (choose text or json)",
+				Value:  "text",
+				EnvVar: "TS_OUTPUT",
+			},
+		},
+		Before: func(c *cli.Context) error {
+			if c.String("output") != "text" && c.String("output") != "json" {
+				return fmt.Errorf("--output must be text or json")
+			}
+			handleInterrupts()
+			transport := http.DefaultTransport
+			if c.Duration("timeout") > 0 {
+				transport = &deadlineTransport{next: transport, timeout: c.Duration("timeout")}
+			}
+			if c.Bool("trace") {
+				transport = &tracingTransport{next: transport, out: os.Stderr}
+			}
+			apiClient = &http.Client{Transport: transport}
+			return nil
 		},
 		Commands: []cli.Command{
 			{
@@ -62,6 +117,12 @@ func main() {
 							{
 								Name:  "online",
 								Usage: "request all online agents",
+								Flags: []cli.Flag{
+									&cli.BoolFlag{
+										Name:  "offline",
+										Usage: "Read from the local cache (see `ts sync`) instead of the API",
+									},
+								},
 								Action: func(c *cli.Context) error {
 									getAgents(c, true)
 									return nil
@@ -70,6 +131,12 @@ func main() {
 							{
 								Name:  "offline",
 								Usage: "request all offline agents",
+								Flags: []cli.Flag{
+									&cli.BoolFlag{
+										Name:  "offline",
+										Usage: "Read from the local cache (see `ts sync`) instead of the API",
+									},
+								},
 								Action: func(c *cli.Context) error {
 									getAgents(c, false)
 									return nil
@@ -109,11 +176,15 @@ func main() {
 									},
 									&cli.StringFlag{
 										Name:  "from, f",
-										Usage: "query for alerts starting from ISO-8610 datetime",
+										Usage: "query for alerts starting from a time (ISO-8601, epoch millis, 24h, 7d, yesterday)",
 									},
 									&cli.StringFlag{
 										Name:  "until, t",
-										Usage: "query for alerts up to ISO-8610 datetime",
+										Usage: "query for alerts up to a time (ISO-8601, epoch millis, 24h, 7d, yesterday)",
+									},
+									&cli.BoolFlag{
+										Name:  "offline",
+										Usage: "Read from the local cache (see `ts sync`) instead of the API",
 									},
 								},
 								Action: func(c *cli.Context) error {
@@ -131,11 +202,15 @@ func main() {
 									},
 									&cli.StringFlag{
 										Name:  "from, f",
-										Usage: "Query for alerts starting from ISO-8610 datetime",
+										Usage: "Query for alerts starting from a time (ISO-8601, epoch millis, 24h, 7d, yesterday)",
 									},
 									&cli.StringFlag{
 										Name:  "until, t",
-										Usage: "Query for alerts up to ISO-8610 datetime",
+										Usage: "Query for alerts up to a time (ISO-8601, epoch millis, 24h, 7d, yesterday)",
+									},
+									&cli.BoolFlag{
+										Name:  "offline",
+										Usage: "Read from the local cache (see `ts sync`) instead of the API",
 									},
 								},
 								Action: func(c *cli.Context) error {
@@ -159,11 +234,11 @@ func main() {
 						Flags: []cli.Flag{
 							&cli.StringFlag{
 								Name:  "from, f",
-								Usage: "Count alerts starting from ISO-8610 datetime",
+								Usage: "Count alerts starting from a time (ISO-8601, epoch millis, 24h, 7d, yesterday)",
 							},
 							&cli.StringFlag{
 								Name:  "until, t",
-								Usage: "Count alerts up to ISO-8610 datetime",
+								Usage: "Count alerts up to a time (ISO-8601, epoch millis, 24h, 7d, yesterday)",
 							},
 						},
 						Action: func(c *cli.Context) error {
@@ -171,9 +246,159 @@ func main() {
 							return nil
 						},
 					},
+					{
+						Name:  "stats",
+						Usage: "summarize alert trends over time (see --help)",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "from, f",
+								Usage: "Summarize alerts starting from a time (ISO-8601, epoch millis, 24h, 7d, yesterday)",
+							},
+							&cli.StringFlag{
+								Name:  "until, t",
+								Usage: "Summarize alerts up to a time (ISO-8601, epoch millis, 24h, 7d, yesterday)",
+							},
+							&cli.StringFlag{
+								Name:  "bucket, b",
+								Usage: "Size of each time bucket (1h, 1d, 1w, etc.)",
+								Value: "1d",
+							},
+							&cli.StringFlag{
+								Name:  "group-by, g",
+								Usage: "Group bucket counts by rule, agent, severity, title, or dataSource",
+								Value: "severity",
+							},
+							&cli.StringFlag{
+								Name:  "status",
+								Usage: "Include active, dismissed, or all alerts",
+								Value: "all",
+							},
+							&cli.IntFlag{
+								Name:  "top, n",
+								Usage: "Number of noisy rules and agents to list",
+								Value: 10,
+							},
+							&cli.StringFlag{
+								Name:  "format",
+								Usage: "Output format (choose table, csv, or json)",
+								Value: "table",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							alertStatsReport(c)
+							return nil
+						},
+					},
+					{
+						Name:  "export",
+						Usage: "export alerts and their events to a directory for forensics (see --help)",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "from, f",
+								Usage: "Export alerts starting from a time (ISO-8601, epoch millis, 24h, 7d, yesterday)",
+							},
+							&cli.StringFlag{
+								Name:  "until, t",
+								Usage: "Export alerts up to a time (ISO-8601, epoch millis, 24h, 7d, yesterday)",
+							},
+							&cli.StringFlag{
+								Name:  "status",
+								Usage: "Export active, dismissed, or all alerts",
+								Value: "all",
+							},
+							&cli.BoolFlag{
+								Name:  "with-events",
+								Usage: "Include each alert's contributing events",
+							},
+							&cli.StringFlag{
+								Name:  "out",
+								Usage: "Write the evidence package to `DIR`",
+							},
+							&cli.IntFlag{
+								Name:  "concurrency, c",
+								Usage: "Number of alerts to fetch events for at once",
+								Value: 4,
+							},
+						},
+						Action: func(c *cli.Context) error {
+							exportAlerts(c)
+							return nil
+						},
+					},
+					{
+						Name:  "forward",
+						Usage: "continuously forward new alerts to a SIEM (see --help)",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "syslog",
+								Usage: "Send alerts to a syslog collector (udp://host:514 or tcp://host:514)",
+							},
+							&cli.StringFlag{
+								Name:  "format",
+								Usage: "Syslog message format (choose cef, leef, or rfc5424)",
+								Value: "cef",
+							},
+							&cli.StringFlag{
+								Name:  "webhook",
+								Usage: "POST alerts to a webhook `URL`",
+							},
+							&cli.StringFlag{
+								Name:  "template",
+								Usage: "Render webhook payloads with the Go text/template in `FILE` (default: alert as JSON)",
+							},
+							&cli.BoolFlag{
+								Name:  "enrich",
+								Usage: "Look up each alert's agent and make it available to the webhook template",
+							},
+							&cli.StringFlag{
+								Name:   "secret",
+								Usage:  "Sign webhook payloads with HMAC-SHA256 using this secret",
+								EnvVar: "TS_WEBHOOK_SECRET",
+							},
+							&cli.IntFlag{
+								Name:  "retries",
+								Usage: "Number of times to retry a failed webhook delivery",
+								Value: 3,
+							},
+							&cli.StringFlag{
+								Name:  "dead-letter",
+								Usage: "Save undeliverable webhook payloads to `DIR`",
+								Value: "ts-forward-deadletter",
+							},
+							&cli.StringFlag{
+								Name:  "checkpoint",
+								Usage: "Remember forwarding progress in `FILE`",
+								Value: "ts-forward-checkpoint.json",
+							},
+							&cli.StringFlag{
+								Name:  "from, f",
+								Usage: "Without a checkpoint, start from a time (ISO-8601, epoch millis, 24h, 7d, yesterday)",
+							},
+							&cli.DurationFlag{
+								Name:  "interval",
+								Usage: "How often to poll for new alerts",
+								Value: time.Minute,
+							},
+							&cli.BoolFlag{
+								Name:  "once",
+								Usage: "Forward whatever is new and exit instead of polling",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							forwardAlerts(c)
+							return nil
+						},
+					},
 					{
 						Name:  "events",
 						Usage: "request contributing events for an alert",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "format",
+								Usage: "Output format (choose json or timeline)",
+								Value: "json",
+							},
+						},
 						Action: func(c *cli.Context) error {
 							getEvents(c)
 							return nil
@@ -207,11 +432,11 @@ func main() {
 						Flags: []cli.Flag{
 							&cli.StringFlag{
 								Name:  "from, f",
-								Usage: "Dismiss alerts starting from ISO-8610 datetime",
+								Usage: "Dismiss alerts starting from a time (ISO-8601, epoch millis, 24h, 7d, yesterday)",
 							},
 							&cli.StringFlag{
 								Name:  "until, t",
-								Usage: "Dismiss alerts up to ISO-8610 datetime",
+								Usage: "Dismiss alerts up to a time (ISO-8601, epoch millis, 24h, 7d, yesterday)",
 							},
 							&cli.StringFlag{
 								Name:  "severity, s",
@@ -235,16 +460,169 @@ func main() {
 							},
 						},
 						Action: func(c *cli.Context) error {
-							dismissAlertsByID(c)
+							dismissAlertsByQueryParameters(c)
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:  "auditlogs",
+				Usage: "Display audit log records for your organization",
+				Subcommands: []cli.Command{
+					{
+						Name:  "list",
+						Usage: "request audit log records",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "from, f",
+								Usage: "Query for records starting from a time (ISO-8601, epoch millis, 24h, 7d, yesterday)",
+							},
+							&cli.StringFlag{
+								Name:  "until, t",
+								Usage: "Query for records up to a time (ISO-8601, epoch millis, 24h, 7d, yesterday)",
+							},
+							&cli.BoolFlag{
+								Name:  "offline",
+								Usage: "Read from the local cache (see `ts sync`) instead of the API",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							getAuditLogs(c)
 							return nil
 						},
 					},
 				},
 			},
+			{
+				Name:  "exporter",
+				Usage: "serve agent and alert metrics for Prometheus (see --help)",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "listen, l",
+						Usage: "Address to serve /metrics on",
+						Value: ":9731",
+					},
+					&cli.DurationFlag{
+						Name:  "interval",
+						Usage: "How often to poll the API",
+						Value: time.Minute,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					runExporter(c)
+					return nil
+				},
+			},
+			{
+				Name:  "sync",
+				Usage: "mirror agents, alerts, members and audit logs into a local cache",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "from, f",
+						Usage: "On the first sync, only fetch alerts and audit logs from a time (ISO-8601, epoch millis, 24h, 7d, yesterday)",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					syncCache(c)
+					return nil
+				},
+			},
+			{
+				Name:      "query",
+				Usage:     "run read-only SQL against the local cache",
+				ArgsUsage: "SQL",
+				Description: "Tables: agents, alerts, members, audit_logs and sync_state. Every table has a json\n" +
+					"   column with the full API object; run `ts query \"SELECT sql FROM sqlite_master\"` for the schema.",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "Output format (choose table, csv, or json)",
+						Value: "table",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					queryCacheSQL(c)
+					return nil
+				},
+			},
 			{
 				Name:  "portability",
 				Usage: "Manage data portability settings",
 				Subcommands: []cli.Command{
+					{
+						Name:  "apply",
+						Usage: "create, update and delete S3 exports to match a YAML file (see --help)",
+						Description: "The file lists exports with the same keys the API uses (s3Bucket,\n" +
+							"   iamRoleArn, iamRoleArnExternalId, region, prefix, enabled), optionally\n" +
+							"   under 'exports:'. Exports not in the file are deleted.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "file, f",
+								Usage: "the exports file",
+							},
+							&cli.BoolFlag{
+								Name:  "dry-run",
+								Usage: "show the plan without changing anything",
+							},
+							&cli.BoolFlag{
+								Name:  "yes, y",
+								Usage: "apply the plan without asking for confirmation",
+							},
+							&cli.BoolFlag{
+								Name:  "allow-empty",
+								Usage: "apply a file with no exports, deleting every S3 export",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							applyPortability(c)
+							return nil
+						},
+					},
+					{
+						Name:      "read",
+						Usage:     "read S3 export data that has been synced to local disk (see --help)",
+						ArgsUsage: "PATH",
+						Description: "Reads every .json, .ndjson and .jsonl file (gzipped or not) under PATH,\n" +
+							"   e.g. after 'aws s3 sync s3://BUCKET/PREFIX PATH'. If PATH holds the whole\n" +
+							"   bucket, pass the export's prefix with --prefix. Output goes through\n" +
+							"   the global --filter and --fields options.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "prefix, p",
+								Usage: "the export's S3 prefix, when PATH is the whole synced bucket",
+							},
+							&cli.StringFlag{
+								Name:  "type, t",
+								Usage: "only records of this type (a directory under the prefix, or the record's type)",
+							},
+							&cli.StringFlag{
+								Name:  "agent, a",
+								Usage: "only records for this agent ID",
+							},
+							&cli.StringFlag{
+								Name:  "from, f",
+								Usage: "only records from a time (ISO-8601, epoch millis, 24h, 7d, yesterday)",
+							},
+							&cli.StringFlag{
+								Name:  "until",
+								Usage: "only records before a time (ISO-8601, epoch millis, 24h, 7d, yesterday)",
+							},
+							&cli.IntFlag{
+								Name:  "limit, n",
+								Usage: "stop after this many records (0 for no limit)",
+							},
+							&cli.StringFlag{
+								Name:  "format",
+								Usage: "Output format (choose json or ndjson)",
+								Value: "json",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							readPortability(c)
+							return nil
+						},
+					},
 					{
 						Name:  "s3",
 						Usage: "display current S3 portability configuration",
@@ -273,6 +651,10 @@ func main() {
 										Name:  "prefix, p",
 										Usage: "Bucket Prefix (folder)",
 									},
+									&cli.BoolFlag{
+										Name:  "check",
+										Usage: "validate the settings locally and explain any problems, without calling the API",
+									},
 								},
 								Action: func(c *cli.Context) error {
 									createS3Portability(c)
@@ -287,6 +669,59 @@ func main() {
 									return nil
 								},
 							},
+							{
+								Name:      "update",
+								Usage:     "change an S3 portability configuration (see --help)",
+								ArgsUsage: "BUCKET",
+								Flags: []cli.Flag{
+									&cli.StringFlag{
+										Name:  "arn, a",
+										Usage: "IAM Role Arn",
+									},
+									&cli.StringFlag{
+										Name:  "externalID, i",
+										Usage: "IAM Role External ID",
+									},
+									&cli.StringFlag{
+										Name:  "region, r",
+										Usage: "AWS Region (us-east-1, etc.)",
+									},
+									&cli.StringFlag{
+										Name:  "prefix, p",
+										Usage: "Bucket Prefix (folder)",
+									},
+									&cli.BoolFlag{
+										Name:  "check",
+										Usage: "validate the settings locally and explain any problems, without calling the API",
+									},
+									&cli.BoolFlag{
+										Name:  "skip-validation",
+										Usage: "send the update even if the enrollment fails the local checks",
+									},
+								},
+								Action: func(c *cli.Context) error {
+									updateS3Portability(c)
+									return nil
+								},
+							},
+							{
+								Name:      "enable",
+								Usage:     "resume exports to an S3 bucket",
+								ArgsUsage: "BUCKET",
+								Action: func(c *cli.Context) error {
+									setS3PortabilityEnabled(c, true)
+									return nil
+								},
+							},
+							{
+								Name:      "disable",
+								Usage:     "pause exports to an S3 bucket without removing its configuration",
+								ArgsUsage: "BUCKET",
+								Action: func(c *cli.Context) error {
+									setS3PortabilityEnabled(c, false)
+									return nil
+								},
+							},
 							{
 								Name:  "delete",
 								Usage: "delete an S3 portability configuration",
@@ -315,20 +750,153 @@ func main() {
 								Name:  "email, em",
 								Usage: "the email to be used to invite the new user",
 							},
+							&cli.StringFlag{
+								Name:  "file, f",
+								Usage: "invite everyone in a YAML or CSV file of email/role pairs",
+							},
 						},
 						Action: func(c *cli.Context) error {
 							inviteUser(c)
 							return nil
 						},
 					},
+					{
+						Name:  "invites",
+						Usage: "manage pending invites",
+						Subcommands: []cli.Command{
+							{
+								Name:  "list",
+								Usage: "show invites that haven't been accepted",
+								Action: func(c *cli.Context) error {
+									getInvites(c)
+									return nil
+								},
+							},
+							{
+								Name:      "resend",
+								Usage:     "send a pending invite again",
+								ArgsUsage: "ID|EMAIL",
+								Action: func(c *cli.Context) error {
+									resendInvite(c)
+									return nil
+								},
+							},
+							{
+								Name:      "revoke",
+								Usage:     "cancel a pending invite",
+								ArgsUsage: "ID|EMAIL",
+								Action: func(c *cli.Context) error {
+									revokeInvite(c)
+									return nil
+								},
+							},
+						},
+					},
 					{
 						Name:  "list",
 						Usage: "show all user from a single organiztion",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "offline",
+								Usage: "Read from the local cache (see `ts sync`) instead of the API",
+							},
+						},
 						Action: func(c *cli.Context) error {
 							getUsers(c)
 							return nil
 						},
 					},
+					{
+						Name:      "show",
+						Usage:     "show a single user by ID or e-mail address",
+						ArgsUsage: "ID|EMAIL",
+						Action: func(c *cli.Context) error {
+							showUser(c)
+							return nil
+						},
+					},
+					{
+						Name:  "update",
+						Usage: "change the role of a user (see --help)",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "userid, uid",
+								Usage: "the id of the user to update",
+							},
+							&cli.StringFlag{
+								Name:  "role, r",
+								Usage: "the new role: 'user', 'reader' or 'admin'",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							updateUser(c)
+							return nil
+						},
+					},
+					{
+						Name:  "sync",
+						Usage: "invite, update and remove users to match a roster file (see --help)",
+						Description: "The roster is YAML (a list of email/role entries, optionally under\n" +
+							"   'members:') or CSV with email,role columns. Users on the roster but not\n" +
+							"   in the organization are invited, and users whose role differs are updated.\n" +
+							"   With --prune, users not on the roster are removed.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "roster, f",
+								Usage: "the roster file (.yaml, .yml or .csv)",
+							},
+							&cli.BoolFlag{
+								Name:  "dry-run",
+								Usage: "show the plan without changing anything",
+							},
+							&cli.BoolFlag{
+								Name:  "prune",
+								Usage: "remove users that aren't on the roster",
+							},
+							&cli.BoolFlag{
+								Name:  "force-prune",
+								Usage: "allow --prune to remove more than half of the organization",
+							},
+							&cli.BoolFlag{
+								Name:  "yes, y",
+								Usage: "apply the plan without asking for confirmation",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							syncMembers(c)
+							return nil
+						},
+					},
+					{
+						Name:  "audit",
+						Usage: "report users without MFA or SSO, disabled users and inactive users (see --help)",
+						Description: "Exits 0 when every member passes, 2 when any member has an issue,\n" +
+							"   and 1 if the audit couldn't be run.",
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:  "inactive-days",
+								Usage: "flag users who haven't signed in for this many days",
+								Value: 90,
+							},
+							&cli.StringFlag{
+								Name:  "skip",
+								Usage: "comma-separated checks to skip (mfa, sso, disabled, inactive)",
+							},
+							&cli.StringFlag{
+								Name:  "format",
+								Usage: "Output format (choose table or json)",
+								Value: "table",
+							},
+							&cli.BoolFlag{
+								Name:  "offline",
+								Usage: "Read from the local cache (see `ts sync`) instead of the API",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							auditMembers(c)
+							return nil
+						},
+					},
 					{
 						Name:  "delete",
 						Usage: "delete user from a single organiztion",
@@ -337,6 +905,14 @@ func main() {
 								Name:  "userid, uid",
 								Usage: "remove user from organization",
 							},
+							&cli.StringFlag{
+								Name:  "email, em",
+								Usage: "remove the user with this e-mail address",
+							},
+							&cli.BoolFlag{
+								Name:  "yes, y",
+								Usage: "delete without asking for confirmation",
+							},
 						},
 						Action: func(c *cli.Context) error {
 							deleteUser(c)
@@ -345,10 +921,40 @@ func main() {
 					},
 				},
 			},
+			{
+				Name:  "auth",
+				Usage: "Store and check API credentials",
+				Subcommands: []cli.Command{
+					{
+						Name:        "login",
+						Usage:       "save your credentials, with the API key encrypted by a passphrase (see --help)",
+						Description: "Prompts for anything not already given by --user, --org, --key (or their TS_* variables). The passphrase can come from TS_KEY_PASSPHRASE instead of a prompt. Credentials are checked against the API before they're saved to --key-file.",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "helper",
+								Usage: "Save a credential helper `COMMAND` to run instead of storing the key (see --credential-helper)",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							authLogin(c)
+							return nil
+						},
+					},
+					{
+						Name:  "status",
+						Usage: "show which credentials are in use, and check them with a signed request",
+						Action: func(c *cli.Context) error {
+							authStatus(c)
+							return nil
+						},
+					},
+				},
+			},
 			{
 				Name:        "raw",
 				Usage:       "send hawk-signed API requests",
 				Description: "The 'Secret Menu' of the TS CLI. Perform any action on any endpoint! Get raw JSON back!",
+				ArgsUsage:   "ENDPOINT",
 				Hidden:      true,
 				Flags: []cli.Flag{
 					&cli.StringFlag{
@@ -358,7 +964,28 @@ func main() {
 					},
 					&cli.StringFlag{
 						Name:  "data, d",
-						Usage: "Raw JSON data to send",
+						Usage: "Raw JSON data to send (@FILE to read it from a file, - to read stdin)",
+					},
+					&cli.StringSliceFlag{
+						Name:  "header, H",
+						Usage: "Extra request header, as 'Name: value' (can be repeated)",
+					},
+					&cli.BoolFlag{
+						Name:  "include, i",
+						Usage: "Print the response status and headers before the body",
+					},
+					&cli.BoolFlag{
+						Name:  "as-curl",
+						Usage: "Print an equivalent signed curl command instead of sending the request",
+					},
+					&cli.BoolFlag{
+						Name:  "paginate",
+						Usage: "Follow the response's \"token\" and fetch every page (GET only)",
+					},
+					&cli.StringFlag{
+						Name:  "paginate-format",
+						Usage: "With --paginate, merge the pages' lists into one response, or print each page as NDJSON (choose merge or ndjson)",
+						Value: "merge",
 					},
 					&cli.BoolFlag{
 						Name:  "debug, z",
@@ -373,9 +1000,12 @@ func main() {
 		},
 	}
 
-	err := app.Run(os.Args)
+	err := app.Run(rawStdinArgs(os.Args))
 	if err != nil {
-		log.Fatal(err)
+		// urfave/cli only returns errors for flags and arguments it
+		// couldn't parse
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitUsage)
 	}
 }
 
@@ -383,17 +1013,20 @@ func noArgs(c *cli.Context) {
 	cli.ShowAppHelp(c)
 }
 
+// apiClient sends every API request. It's set up in the app's Before hook
+// with the --timeout and --trace transports.
+var apiClient = &http.Client{}
+
 // tsBuildHTTPReq - function for using CLI context to build a HAWK request
 func tsBuildHTTPReq(c *cli.Context, method string, endpoint string, payload []byte) (*http.Request, error) {
-	config := tsapi.Config{
-		User: c.GlobalString("user"),
-		Key:  c.GlobalString("key"),
-		Org:  c.GlobalString("org"),
+	creds, err := loadCredentials(c)
+	if err != nil {
+		return nil, err
 	}
 
-	req, err := tsapi.Request(config, method, c.GlobalString("endpoint")+endpoint, payload)
+	req, err := tsapi.Request(creds.Config, method, c.GlobalString("endpoint")+endpoint, payload)
 	if err != nil {
 		return req, err
 	}
-	return req, nil
+	return req.WithContext(appContext), nil
 }
@@ -8,19 +8,21 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
-	"net/http"
-	"os"
+	"strings"
 
 	tsapi "github.com/threatstack/ts/api"
 	"github.com/urfave/cli"
 )
 
 func inviteUser(c *cli.Context) {
-	client := &http.Client{}
-	inviteEndpoint := "/v2/organizations/invites"
+	if c.String("file") != "" {
+		bulkInviteUsers(c)
+		return
+	}
+
 	validInput := true
 	roleUserInput := true
 	roleReaderInput := true
@@ -51,12 +53,7 @@ func inviteUser(c *cli.Context) {
 	}
 
 	if !validInput {
-		cli.ShowSubcommandHelp(c)
-		fmt.Printf("\nERROR: Unable to create enrollment request.\n")
-		for _, v := range errs {
-			fmt.Printf("         * %s\n", v)
-		}
-		os.Exit(1)
+		failUsage(c, "Unable to create enrollment request.", errs...)
 	}
 
 	integrationToCreate := tsapi.InvitePost{
@@ -64,126 +61,245 @@ func inviteUser(c *cli.Context) {
 		Email: c.String("email"),
 	}
 
-	reqJSON, err := json.Marshal(integrationToCreate)
+	enrollmentResponse, err := sendInvite(c, integrationToCreate)
+	if err != nil {
+		fail(c, fmt.Errorf("Unable to send invite. %w", err))
+	}
+
+	fmt.Printf("Invite request sent\n")
+	fmt.Printf("----------------------------------------------------------------------\n")
+	fmt.Printf("Email Set to:         %s\n", enrollmentResponse.SentToEmail)
+	fmt.Printf("Role: %s\n", enrollmentResponse.Role)
+	fmt.Printf("Status:       %s\n", enrollmentResponse.Status)
+}
+
+// sendInvite - invite a user to the organization
+func sendInvite(c *cli.Context, invite tsapi.InvitePost) (tsapi.InviteResponse, error) {
+	var enrollmentResponse tsapi.InviteResponse
+	inviteEndpoint := "/v2/organizations/invites"
+
+	reqJSON, err := json.Marshal(invite)
 	if err != nil {
-		log.Fatalln(err)
+		return enrollmentResponse, err
 	}
 
 	req, err := tsBuildHTTPReq(c, "PUT", inviteEndpoint, reqJSON)
 	if err != nil {
-		log.Fatalln(err)
+		return enrollmentResponse, err
 	}
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
-		log.Fatalln(err)
+		return enrollmentResponse, err
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalln(err)
+		return enrollmentResponse, err
 	}
 
-	if resp.StatusCode == 200 {
-		var enrollmentResponse tsapi.InviteResponse
-		if err := json.Unmarshal(body, &enrollmentResponse); err != nil {
-			log.Fatalln(err)
-		}
-
-		fmt.Printf("Invite request sent\n")
-		fmt.Printf("----------------------------------------------------------------------\n")
-		fmt.Printf("Email Set to:         %s\n", enrollmentResponse.SentToEmail)
-		fmt.Printf("Role: %s\n", enrollmentResponse.Role)
-		fmt.Printf("Status:       %s\n", enrollmentResponse.Status)
+	if resp.StatusCode != 200 {
+		return enrollmentResponse, tsapi.NewAPIError(resp.StatusCode, inviteEndpoint, body)
+	}
+	err = json.Unmarshal(body, &enrollmentResponse)
+	return enrollmentResponse, err
+}
 
+func getUsers(c *cli.Context) {
+	var members []tsapi.Member
+	var err error
+	if c.Bool("offline") {
+		members, err = cachedMembers(c)
 	} else {
-		fmt.Printf("Unable to send invite. The API responded with an HTTP/%d.\n", resp.StatusCode)
-		var errResponse tsapi.Error
-		if err := json.Unmarshal(body, &errResponse); err != nil {
-			log.Fatalln(err)
-		} else {
-			for _, v := range errResponse.Errors {
-				fmt.Printf("* %s\n", v)
-			}
-		}
+		members, err = queryMembers(c)
+	}
+	if err != nil {
+		fail(c, err)
 	}
+	printJSON(c, members)
 }
 
-func getUsers(c *cli.Context) {
-	var member []tsapi.Member
-	client := &http.Client{}
+// queryMembers - fetch every member of the organization
+func queryMembers(c *cli.Context) ([]tsapi.Member, error) {
+	var enrollments tsapi.MembersResponseRaw
 	OrgMembersEndpoint := "/v2/organizations/members"
 	req, err := tsBuildHTTPReq(c, "GET", OrgMembersEndpoint, nil)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode == 200 {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			log.Fatalln(err)
-		}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, tsapi.NewAPIError(resp.StatusCode, OrgMembersEndpoint, body)
+	}
+	if err := json.Unmarshal(body, &enrollments); err != nil {
+		return nil, err
+	}
+	return enrollments.Members, nil
+}
 
-		var enrollments tsapi.MembersResponseRaw
+func deleteUser(c *cli.Context) {
+	validInput := true
+	var errs []string
 
-		if err := json.Unmarshal(body, &enrollments); err != nil {
-			log.Fatalln(err)
+	if c.String("userid") == "" && c.String("email") == "" {
+		errs = append(errs, "Missing the user id or e-mail address to delete")
+		validInput = false
+	}
+	if c.String("userid") != "" && c.String("email") != "" {
+		errs = append(errs, "Use only one of --userid or --email")
+		validInput = false
+	}
+	if !validInput {
+		failUsage(c, "Unable to do delete user request.", errs...)
+	}
+
+	members, err := queryMembers(c)
+	if err != nil {
+		fail(c, err)
+	}
+	var member *tsapi.Member
+	for i := range members {
+		if (c.String("userid") != "" && members[i].ID == c.String("userid")) ||
+			(c.String("email") != "" && strings.EqualFold(members[i].Email, c.String("email"))) {
+			member = &members[i]
+			break
 		}
-		member = append(member, enrollments.Members...)
 	}
-	ser, err := json.Marshal(member)
+	if member == nil {
+		fail(c, notFound("No member found with ID or e-mail %s%s", c.String("userid"), c.String("email")))
+	}
+	creds, err := loadCredentials(c)
 	if err != nil {
-		log.Fatalln(err)
+		fail(c, err)
+	}
+	if member.ID == creds.User {
+		fail(c, fmt.Errorf("Refusing to delete %s: that's the user these credentials belong to.", member.Email))
+	}
+
+	fmt.Printf("Member to delete\n")
+	fmt.Printf("----------------------------------------------------------------------\n")
+	fmt.Printf("ID:           %s\n", member.ID)
+	fmt.Printf("Name:         %s\n", member.DisplayName)
+	fmt.Printf("Email:        %s\n", member.Email)
+	fmt.Printf("Role:         %s\n", member.Role)
+
+	if !c.Bool("yes") && !confirm("Delete this member?") {
+		fail(c, errors.New("Aborted, member not deleted."))
 	}
-	fmt.Println(string(ser))
 
+	if err := sendMemberDelete(c, member.ID); err != nil {
+		fail(c, fmt.Errorf("Unable to delete member. %w", err))
+	}
+	fmt.Printf("Deleted member %s\n", member.Email)
 }
 
-func deleteUser(c *cli.Context) {
-	client := &http.Client{}
+func showUser(c *cli.Context) {
+	if c.Args().Get(0) == "" {
+		failUsage(c, "Specify the user ID or e-mail address you want to look up as an argument.")
+	}
+
+	members, err := queryMembers(c)
+	if err != nil {
+		fail(c, err)
+	}
+	for _, member := range members {
+		if member.ID == c.Args().Get(0) || strings.EqualFold(member.Email, c.Args().Get(0)) {
+			printJSON(c, member)
+			return
+		}
+	}
+	fail(c, notFound("No member found with ID or e-mail %s", c.Args().Get(0)))
+}
+
+func updateUser(c *cli.Context) {
 	validInput := true
+
 	var errs []string
 
 	if c.String("userid") == "" {
-		errs = append(errs, "the user id to delete")
+		errs = append(errs, "Missing the user id to update")
+		validInput = false
+	}
+
+	if c.String("role") == "" {
+		errs = append(errs, "Missing the new role for the user")
+		validInput = false
+	} else if c.String("role") != "user" && c.String("role") != "reader" && c.String("role") != "admin" {
+		errs = append(errs, "Role for the user is not set 'user', 'reader' or 'admin'")
 		validInput = false
 	}
+
 	if !validInput {
-		cli.ShowSubcommandHelp(c)
-		fmt.Printf("\nERROR: Unable to do delete user request.\n")
-		for _, v := range errs {
-			fmt.Printf("         * %s\n", v)
-		}
-		os.Exit(1)
+		failUsage(c, "Unable to create update request.", errs...)
 	}
 
-	OrgMemberDeleteEndpoint := "/v2/organizations/members/"
-	OrgMemberDeleteEndpoint += c.String("userid")
-	fmt.Println(OrgMemberDeleteEndpoint)
+	if err := sendMemberUpdate(c, c.String("userid"), c.String("role")); err != nil {
+		fail(c, fmt.Errorf("Unable to update member. %w", err))
+	}
 
-	req, err := tsBuildHTTPReq(c, "DELETE", OrgMemberDeleteEndpoint, nil)
+	fmt.Printf("Updated member %s\n", c.String("userid"))
+	fmt.Printf("----------------------------------------------------------------------\n")
+	fmt.Printf("Role:         %s\n", c.String("role"))
+}
+
+// sendMemberUpdate - change a member's role
+func sendMemberUpdate(c *cli.Context, id string, role string) error {
+	memberUpdate := tsapi.MemberUpdate{
+		Role: role,
+	}
+
+	reqJSON, err := json.Marshal(memberUpdate)
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
-	resp, err := client.Do(req)
+
+	OrgMemberEndpoint := tsapi.Path("v2", "organizations", "members", id)
+	req, err := tsBuildHTTPReq(c, "PUT", OrgMemberEndpoint, reqJSON)
 	if err != nil {
-		log.Fatalln(err)
+		return err
+	}
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode == 204 {
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			log.Fatalln(err)
-		}
-		fmt.Printf("%s\n", body)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
 	}
+
+	if resp.StatusCode != 200 && resp.StatusCode != 204 {
+		return tsapi.NewAPIError(resp.StatusCode, OrgMemberEndpoint, body)
+	}
+	return nil
+}
+
+// sendMemberDelete - remove a member from the organization
+func sendMemberDelete(c *cli.Context, id string) error {
+	OrgMemberDeleteEndpoint := tsapi.Path("v2", "organizations", "members", id)
+	req, err := tsBuildHTTPReq(c, "DELETE", OrgMemberDeleteEndpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalln(err)
+		return err
 	}
 
-	fmt.Printf("%s\n", body)
+	if resp.StatusCode != 200 && resp.StatusCode != 204 {
+		return tsapi.NewAPIError(resp.StatusCode, OrgMemberDeleteEndpoint, body)
+	}
+	return nil
 }
@@ -0,0 +1,159 @@
+// ts - golang ts api client
+// membersaudit.go: report members that don't meet account security policy
+//
+// Copyright 2022 F5, Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	tsapi "github.com/threatstack/ts/api"
+	"github.com/urfave/cli"
+)
+
+// memberAuditChecks are the checks `ts members audit` knows how to run
+var memberAuditChecks = []string{"mfa", "sso", "disabled", "inactive"}
+
+// memberFinding is one member that failed one or more audit checks
+type memberFinding struct {
+	ID                  string   `json:"id"`
+	Email               string   `json:"email"`
+	Role                string   `json:"role"`
+	LastAuthenticatedAt string   `json:"lastAuthenticatedAt,omitempty"`
+	Issues              []string `json:"issues"`
+}
+
+// memberAuditReport is the JSON form of `ts members audit`
+type memberAuditReport struct {
+	Members  int             `json:"members"`
+	Findings []memberFinding `json:"findings"`
+}
+
+func auditMembers(c *cli.Context) {
+	var errs []string
+	if c.Int("inactive-days") < 1 {
+		errs = append(errs, "--inactive-days must be at least 1")
+	}
+	if c.String("format") != "table" && c.String("format") != "json" {
+		errs = append(errs, "Output format must be table or json")
+	}
+	checks, err := parseAuditChecks(c.String("skip"))
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		failUsage(c, "Unable to run the member audit.", errs...)
+	}
+
+	var members []tsapi.Member
+	if c.Bool("offline") {
+		members, err = cachedMembers(c)
+	} else {
+		members, err = queryMembers(c)
+	}
+	if err != nil {
+		fail(c, err)
+	}
+
+	inactiveSince := time.Now().AddDate(0, 0, -c.Int("inactive-days"))
+	report := memberAuditReport{
+		Members:  len(members),
+		Findings: auditMemberList(members, checks, inactiveSince),
+	}
+
+	if c.String("format") == "json" {
+		printJSON(c, report)
+	} else {
+		writeMemberAuditTable(report)
+	}
+
+	// A distinct exit code lets CI tell "policy violations" apart from
+	// "couldn't run the audit".
+	if len(report.Findings) > 0 {
+		os.Exit(exitFindings)
+	}
+}
+
+// parseAuditChecks - the checks to run, given a comma-separated list to skip
+func parseAuditChecks(skip string) (map[string]bool, error) {
+	checks := make(map[string]bool)
+	for _, check := range memberAuditChecks {
+		checks[check] = true
+	}
+	for _, check := range strings.Split(skip, ",") {
+		check = strings.TrimSpace(check)
+		if check == "" {
+			continue
+		}
+		if !checks[check] {
+			return nil, fmt.Errorf("Unknown check %q in --skip (choose from %s)", check, strings.Join(memberAuditChecks, ", "))
+		}
+		checks[check] = false
+	}
+	return checks, nil
+}
+
+// auditMemberList - run the enabled checks against each member.
+// LastAuthenticatedAt is epoch millis, and 0 means the member never signed in.
+func auditMemberList(members []tsapi.Member, checks map[string]bool, inactiveSince time.Time) []memberFinding {
+	findings := []memberFinding{}
+	for _, member := range members {
+		finding := memberFinding{ID: member.ID, Email: member.Email, Role: member.Role}
+		var lastAuth time.Time
+		if member.LastAuthenticatedAt > 0 {
+			lastAuth = time.UnixMilli(int64(member.LastAuthenticatedAt))
+			finding.LastAuthenticatedAt = lastAuth.UTC().Format(time.RFC3339)
+		}
+
+		if checks["mfa"] && !member.MFAEnabled {
+			finding.Issues = append(finding.Issues, "no MFA")
+		}
+		if checks["sso"] && !member.SSOEnabled {
+			finding.Issues = append(finding.Issues, "no SSO")
+		}
+		if checks["disabled"] && !member.UserEnabled {
+			finding.Issues = append(finding.Issues, "disabled")
+		}
+		if checks["inactive"] {
+			if lastAuth.IsZero() {
+				finding.Issues = append(finding.Issues, "never signed in")
+			} else if lastAuth.Before(inactiveSince) {
+				days := int(time.Since(lastAuth).Hours() / 24)
+				finding.Issues = append(finding.Issues, fmt.Sprintf("inactive %dd", days))
+			}
+		}
+
+		if len(finding.Issues) > 0 {
+			findings = append(findings, finding)
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return strings.ToLower(findings[i].Email) < strings.ToLower(findings[j].Email)
+	})
+	return findings
+}
+
+func writeMemberAuditTable(report memberAuditReport) {
+	if len(report.Findings) == 0 {
+		fmt.Printf("All %d members pass.\n", report.Members)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "EMAIL\tROLE\tLAST SIGN-IN\tISSUES\n")
+	for _, finding := range report.Findings {
+		lastAuth := finding.LastAuthenticatedAt
+		if lastAuth == "" {
+			lastAuth = "never"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", finding.Email, finding.Role, lastAuth, strings.Join(finding.Issues, ", "))
+	}
+	w.Flush()
+	fmt.Printf("\n%d of %d members have issues.\n", len(report.Findings), report.Members)
+}
@@ -0,0 +1,331 @@
+// ts - golang ts api client
+// memberssync.go: bring organization membership in line with a roster file
+//
+// Copyright 2022 F5, Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	tsapi "github.com/threatstack/ts/api"
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v3"
+)
+
+// rosterEntry is one person who should be a member of the organization
+type rosterEntry struct {
+	Email string `yaml:"email"`
+	Role  string `yaml:"role"`
+}
+
+// rosterFile is the YAML roster layout; a bare list of entries works too
+type rosterFile struct {
+	Members []rosterEntry `yaml:"members"`
+}
+
+// memberChange is one step of a membership sync plan
+type memberChange struct {
+	Action string // invite, update, delete, or skip for a note only
+	Email  string
+	ID     string
+	From   string
+	Role   string
+	Note   string
+}
+
+func syncMembers(c *cli.Context) {
+	if c.String("roster") == "" {
+		failUsage(c, "Specify the roster file with --roster.")
+	}
+
+	roster, err := loadRoster(c.String("roster"))
+	if err != nil {
+		fail(c, fmt.Errorf("Unable to load roster. %w", err))
+	}
+
+	members, err := queryMembers(c)
+	if err != nil {
+		fail(c, err)
+	}
+	invites, err := queryInvites(c)
+	if err != nil {
+		fail(c, err)
+	}
+
+	creds, err := loadCredentials(c)
+	if err != nil {
+		fail(c, err)
+	}
+	plan := planMemberSync(roster, members, invites, c.Bool("prune"), creds.User)
+	printMemberPlan(os.Stdout, plan)
+	var changes []memberChange
+	for _, change := range plan {
+		if change.Action != "skip" {
+			changes = append(changes, change)
+		}
+	}
+	if len(changes) == 0 || c.Bool("dry-run") {
+		return
+	}
+
+	// A roster that's wrong (the wrong file, say) shouldn't be able to
+	// empty the organization without somebody saying so
+	deletes := 0
+	for _, change := range changes {
+		if change.Action == "delete" {
+			deletes++
+		}
+	}
+	if deletes*2 > len(members) && !c.Bool("force-prune") {
+		fail(c, &cliError{
+			code:    exitUsage,
+			message: fmt.Sprintf("Refusing to delete %d of %d members. Check the roster, or pass --force-prune if that's really what you want.", deletes, len(members)),
+		})
+	}
+
+	if !c.Bool("yes") && !confirm("Apply these changes?") {
+		fail(c, errors.New("Aborted, no changes made."))
+	}
+
+	failed := 0
+	for _, change := range changes {
+		var err error
+		switch change.Action {
+		case "invite":
+			_, err = sendInvite(c, tsapi.InvitePost{Email: change.Email, Role: change.Role})
+		case "update":
+			err = sendMemberUpdate(c, change.ID, change.Role)
+		case "delete":
+			err = sendMemberDelete(c, change.ID)
+		}
+		if err != nil {
+			failed++
+			fmt.Printf("FAILED %s %s: %s\n", change.Action, change.Email, err)
+			continue
+		}
+		fmt.Printf("OK     %s %s\n", change.Action, change.Email)
+	}
+	if failed > 0 {
+		fmt.Printf("\n")
+		fail(c, fmt.Errorf("%d of %d changes failed.", failed, len(changes)))
+	}
+}
+
+// loadRoster - read a roster from YAML or CSV, picked by file extension.
+// Any invalid entry fails the whole roster.
+func loadRoster(path string) ([]rosterEntry, error) {
+	entries, err := readRoster(path)
+	if err != nil {
+		return nil, err
+	}
+	var errs []string
+	for _, problem := range rosterProblems(entries) {
+		if problem != "" {
+			errs = append(errs, problem)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("\n         * %s", strings.Join(errs, "\n         * "))
+	}
+	return entries, nil
+}
+
+// readRoster - read a roster's entries, with e-mail addresses trimmed and
+// roles lowercased, without checking them
+func readRoster(path string) ([]rosterEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []rosterEntry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		entries, err = parseRosterCSV(file)
+	case ".yaml", ".yml":
+		entries, err = parseRosterYAML(file)
+	default:
+		return nil, fmt.Errorf("Unknown roster format %q (use .yaml, .yml or .csv)", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s has no entries", path)
+	}
+	for i, entry := range entries {
+		entries[i].Email = strings.TrimSpace(entry.Email)
+		entries[i].Role = strings.ToLower(strings.TrimSpace(entry.Role))
+	}
+	return entries, nil
+}
+
+// rosterProblems - what's wrong with each roster entry, or "" if nothing is.
+// Only the second and later copies of a repeated address are flagged.
+func rosterProblems(entries []rosterEntry) []string {
+	problems := make([]string, len(entries))
+	seen := make(map[string]bool)
+	for i, entry := range entries {
+		key := strings.ToLower(entry.Email)
+		switch {
+		case entry.Email == "":
+			problems[i] = fmt.Sprintf("entry %d has no email", i+1)
+		case seen[key]:
+			problems[i] = fmt.Sprintf("%s is listed more than once", entry.Email)
+		case entry.Role != "user" && entry.Role != "reader" && entry.Role != "admin":
+			problems[i] = fmt.Sprintf("%s has role %q, not 'user', 'reader' or 'admin'", entry.Email, entry.Role)
+		}
+		seen[key] = true
+	}
+	return problems
+}
+
+func parseRosterYAML(r io.Reader) ([]rosterEntry, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if yamlIsList(data) {
+		var entries []rosterEntry
+		err := unmarshalYAMLStrict(data, &entries)
+		return entries, err
+	}
+	var roster rosterFile
+	err = unmarshalYAMLStrict(data, &roster)
+	return roster.Members, err
+}
+
+// unmarshalYAMLStrict - like yaml.Unmarshal, but a misspelled key is an
+// error rather than silently ignored. An empty document decodes to nothing.
+func unmarshalYAMLStrict(data []byte, out interface{}) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(out); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// yamlIsList - whether a YAML document is a list at the top level
+func yamlIsList(data []byte) bool {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 {
+		return false
+	}
+	return doc.Content[0].Kind == yaml.SequenceNode
+}
+
+// parseRosterCSV reads email,role rows, with or without a header row
+func parseRosterCSV(r io.Reader) ([]rosterEntry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 && strings.EqualFold(records[0][0], "email") {
+		records = records[1:]
+	}
+	var entries []rosterEntry
+	for _, record := range records {
+		entries = append(entries, rosterEntry{Email: record[0], Role: record[1]})
+	}
+	return entries, nil
+}
+
+// planMemberSync - work out the invites, role changes and (with prune)
+// deletions needed to make the organization match the roster. The calling
+// user is never deleted, and people with a pending invite aren't invited
+// again.
+func planMemberSync(roster []rosterEntry, members []tsapi.Member, invites []tsapi.InviteResponse, prune bool, self string) []memberChange {
+	byEmail := make(map[string]tsapi.Member)
+	for _, member := range members {
+		byEmail[strings.ToLower(member.Email)] = member
+	}
+	pending := pendingInvites(invites)
+
+	var plan []memberChange
+	listed := make(map[string]bool)
+	for _, entry := range roster {
+		listed[strings.ToLower(entry.Email)] = true
+		member, ok := byEmail[strings.ToLower(entry.Email)]
+		if !ok && pending[strings.ToLower(entry.Email)] {
+			plan = append(plan, memberChange{Action: "skip", Email: entry.Email, Role: entry.Role, Note: "already has a pending invite"})
+			continue
+		}
+		if !ok {
+			change := memberChange{Action: "invite", Email: entry.Email, Role: entry.Role}
+			// Invites only take 'user' or 'reader'.
+			if entry.Role == "admin" {
+				change.Role = "user"
+				change.Note = "promote to admin after they accept"
+			}
+			plan = append(plan, change)
+			continue
+		}
+		if member.Role != entry.Role {
+			plan = append(plan, memberChange{Action: "update", Email: member.Email, ID: member.ID, From: member.Role, Role: entry.Role})
+		}
+	}
+
+	if prune {
+		for _, member := range members {
+			if listed[strings.ToLower(member.Email)] {
+				continue
+			}
+			if member.ID == self {
+				plan = append(plan, memberChange{Action: "skip", Email: member.Email, ID: member.ID, From: member.Role, Note: "not on the roster, but is the current user"})
+				continue
+			}
+			plan = append(plan, memberChange{Action: "delete", Email: member.Email, ID: member.ID, From: member.Role})
+		}
+	}
+
+	order := map[string]int{"invite": 0, "update": 1, "delete": 2, "skip": 3}
+	sort.SliceStable(plan, func(i, j int) bool {
+		return order[plan[i].Action] < order[plan[j].Action]
+	})
+	return plan
+}
+
+func printMemberPlan(w io.Writer, plan []memberChange) {
+	counts := make(map[string]int)
+	for _, change := range plan {
+		counts[change.Action]++
+	}
+	if len(plan) == counts["skip"] {
+		fmt.Fprintf(w, "Membership already matches the roster.\n")
+	}
+	for _, change := range plan {
+		switch change.Action {
+		case "invite":
+			fmt.Fprintf(w, "  + invite %s as %s", change.Email, change.Role)
+		case "update":
+			fmt.Fprintf(w, "  ~ update %s: %s -> %s", change.Email, change.From, change.Role)
+		case "delete":
+			fmt.Fprintf(w, "  - delete %s (%s)", change.Email, change.From)
+		case "skip":
+			fmt.Fprintf(w, "    skip %s", change.Email)
+		}
+		if change.Note != "" {
+			fmt.Fprintf(w, " (%s)", change.Note)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	if len(plan) > counts["skip"] {
+		fmt.Fprintf(w, "\nPlan: %d to invite, %d to update, %d to delete.\n", counts["invite"], counts["update"], counts["delete"])
+	}
+}
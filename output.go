@@ -0,0 +1,128 @@
+// ts - golang ts api client
+// output.go: --filter and --fields handling for JSON output
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jmespath/go-jmespath"
+	"github.com/urfave/cli"
+)
+
+// printJSON - print v as JSON, after applying the global --filter
+// (a JMESPath expression) and --fields projection
+func printJSON(c *cli.Context, v interface{}) {
+	result, err := filterOutput(c.GlobalString("filter"), c.GlobalString("fields"), v)
+	if err != nil {
+		fail(c, err)
+	}
+	ser, err := json.Marshal(result)
+	if err != nil {
+		fail(c, err)
+	}
+	fmt.Println(string(ser))
+}
+
+// printBody - print a raw JSON response body, going through printJSON
+// only if there's filtering to do
+func printBody(c *cli.Context, body []byte) {
+	if c.GlobalString("filter") == "" && c.GlobalString("fields") == "" {
+		fmt.Printf("%s\n", body)
+		return
+	}
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		fail(c, err)
+	}
+	printJSON(c, data)
+}
+
+// filterOutput - run v through a JMESPath filter and keep only the listed
+// fields. Both are optional; without either, v is returned as-is.
+func filterOutput(filter string, fields string, v interface{}) (interface{}, error) {
+	if filter == "" && fields == "" {
+		return v, nil
+	}
+
+	// Round-trip through JSON so the filter sees the same field names
+	// the user sees in the output.
+	ser, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	if err := json.Unmarshal(ser, &data); err != nil {
+		return nil, err
+	}
+
+	if filter != "" {
+		data, err = jmespath.Search(filter, data)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --filter expression: %s", err)
+		}
+	}
+
+	if fields != "" {
+		var paths []string
+		for _, field := range strings.Split(fields, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				paths = append(paths, field)
+			}
+		}
+		data = projectFields(data, paths)
+	}
+	return data, nil
+}
+
+// projectFields - keep only the given (possibly dotted) fields of an
+// object, or of each object in a list
+func projectFields(data interface{}, paths []string) interface{} {
+	switch value := data.(type) {
+	case []interface{}:
+		projected := make([]interface{}, len(value))
+		for i, item := range value {
+			projected[i] = projectFields(item, paths)
+		}
+		return projected
+	case map[string]interface{}:
+		projected := make(map[string]interface{})
+		for _, path := range paths {
+			if field, ok := lookupField(value, strings.Split(path, ".")); ok {
+				setField(projected, strings.Split(path, "."), field)
+			}
+		}
+		return projected
+	}
+	return data
+}
+
+func lookupField(object map[string]interface{}, path []string) (interface{}, bool) {
+	field, ok := object[path[0]]
+	if !ok || len(path) == 1 {
+		return field, ok
+	}
+	child, isObject := field.(map[string]interface{})
+	if !isObject {
+		return nil, false
+	}
+	return lookupField(child, path[1:])
+}
+
+func setField(object map[string]interface{}, path []string, field interface{}) {
+	if len(path) == 1 {
+		object[path[0]] = field
+		return
+	}
+	child, ok := object[path[0]].(map[string]interface{})
+	if !ok {
+		child = make(map[string]interface{})
+		object[path[0]] = child
+	}
+	setField(child, path[1:], field)
+}
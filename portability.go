@@ -10,8 +10,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
-	"net/http"
 	"os"
 
 	tsapi "github.com/threatstack/ts/api"
@@ -19,35 +17,6 @@ import (
 )
 
 func createS3Portability(c *cli.Context) {
-	client := &http.Client{}
-	s3PortabilityEndpoint := "/v2/integrations/s3export"
-	validInput := true
-	var errs []string
-
-	if c.String("s3bucket") == "" {
-		errs = append(errs, "Missing S3 Bucket Name")
-		validInput = false
-	}
-
-	if c.String("arn") == "" {
-		errs = append(errs, "Missing IAM Role ARN")
-		validInput = false
-	}
-
-	if c.String("region") == "" {
-		errs = append(errs, "Missing AWS Region")
-		validInput = false
-	}
-
-	if !validInput {
-		cli.ShowSubcommandHelp(c)
-		fmt.Printf("\nERROR: Unable to create enrollment request.\n")
-		for _, v := range errs {
-			fmt.Printf("         * %s\n", v)
-		}
-		os.Exit(1)
-	}
-
 	integrationToCreate := tsapi.S3ExportEnrollment{
 		Enabled:              true,
 		S3Bucket:             c.String("s3bucket"),
@@ -57,74 +26,73 @@ func createS3Portability(c *cli.Context) {
 		Prefix:               c.String("prefix"),
 	}
 
-	reqJSON, err := json.Marshal(integrationToCreate)
-	if err != nil {
-		log.Fatalln(err)
+	if c.Bool("check") {
+		if !printS3Checks(integrationToCreate) {
+			os.Exit(exitFindings)
+		}
+		return
 	}
 
-	req, err := tsBuildHTTPReq(c, "PUT", s3PortabilityEndpoint, reqJSON)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Fatalln(err)
+	if errs := s3EnrollmentProblems(integrationToCreate); len(errs) > 0 {
+		failUsage(c, "Unable to create enrollment request.", errs...)
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	warnS3Enrollment(integrationToCreate)
+
+	enrollmentResponse, err := putS3Enrollment(c, integrationToCreate)
 	if err != nil {
-		log.Fatalln(err)
+		fail(c, fmt.Errorf("Unable to create S3 Enrollment. %w", err))
 	}
+	printS3Enrollment(fmt.Sprintf("Created S3 Enrollment at %s", enrollmentResponse.EnrolledAt), enrollmentResponse)
+}
 
-	if resp.StatusCode == 200 {
-		var enrollmentResponse tsapi.S3ExportEnrollmentResponse
-		if err := json.Unmarshal(body, &enrollmentResponse); err != nil {
-			log.Fatalln(err)
-		}
+// putS3Enrollment - send an enrollment up. The API keys enrollments by
+// bucket, so this both creates new enrollments and replaces existing ones.
+func putS3Enrollment(c *cli.Context, enrollment tsapi.S3ExportEnrollment) (tsapi.S3ExportEnrollmentResponse, error) {
+	var enrollmentResponse tsapi.S3ExportEnrollmentResponse
+	s3PortabilityEndpoint := "/v2/integrations/s3export"
 
-		fmt.Printf("Created S3 Enrollment at %s\n", enrollmentResponse.EnrolledAt)
-		fmt.Printf("----------------------------------------------------------------------\n")
-		fmt.Printf("Enabled:         %t\n", enrollmentResponse.Enabled)
-		fmt.Printf("Organization ID: %s\n", enrollmentResponse.OrganizationID)
-		fmt.Printf("S3 Bucket:       %s\n", enrollmentResponse.S3Bucket)
-		fmt.Printf("IAM Role ARN:    %s\n", enrollmentResponse.IAMRoleARN)
-		fmt.Printf("IAM External ID: %s\n", enrollmentResponse.IAMRoleARNExternalID)
-		fmt.Printf("Region:          %s\n", enrollmentResponse.Region)
-		fmt.Printf("Prefix:          %s\n", enrollmentResponse.Prefix)
-	} else {
-		fmt.Printf("Unable to create S3 Enrollment. The API responded with an HTTP/%d.\n", resp.StatusCode)
-		var errResponse tsapi.Error
-		if err := json.Unmarshal(body, &errResponse); err != nil {
-			log.Fatalln(err)
-		} else {
-			for _, v := range errResponse.Errors {
-				fmt.Printf("* %s\n", v)
-			}
-		}
+	reqJSON, err := json.Marshal(enrollment)
+	if err != nil {
+		return enrollmentResponse, err
 	}
-}
 
-func getS3Portability(c *cli.Context) {
-	client := &http.Client{}
-	s3PortabilityEndpoint := "/v2/integrations/s3export"
-	req, err := tsBuildHTTPReq(c, "GET", s3PortabilityEndpoint, nil)
+	req, err := tsBuildHTTPReq(c, "PUT", s3PortabilityEndpoint, reqJSON)
 	if err != nil {
-		log.Fatalln(err)
+		return enrollmentResponse, err
 	}
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
-		log.Fatalln(err)
+		return enrollmentResponse, err
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalln(err)
+		return enrollmentResponse, err
 	}
 
-	var enrollments []tsapi.S3ExportEnrollmentResponse
+	if resp.StatusCode != 200 {
+		return enrollmentResponse, tsapi.NewAPIError(resp.StatusCode, s3PortabilityEndpoint, body)
+	}
+	err = json.Unmarshal(body, &enrollmentResponse)
+	return enrollmentResponse, err
+}
 
-	if err := json.Unmarshal(body, &enrollments); err != nil {
-		log.Fatalln(err)
+func printS3Enrollment(title string, enrollment tsapi.S3ExportEnrollmentResponse) {
+	fmt.Printf("%s\n", title)
+	fmt.Printf("----------------------------------------------------------------------\n")
+	fmt.Printf("Enabled:         %t\n", enrollment.Enabled)
+	fmt.Printf("Organization ID: %s\n", enrollment.OrganizationID)
+	fmt.Printf("S3 Bucket:       %s\n", enrollment.S3Bucket)
+	fmt.Printf("IAM Role ARN:    %s\n", enrollment.IAMRoleARN)
+	fmt.Printf("IAM External ID: %s\n", enrollment.IAMRoleARNExternalID)
+	fmt.Printf("Region:          %s\n", enrollment.Region)
+	fmt.Printf("Prefix:          %s\n", enrollment.Prefix)
+}
+
+func getS3Portability(c *cli.Context) {
+	enrollments, err := queryS3Enrollments(c)
+	if err != nil {
+		fail(c, err)
 	}
 
 	if len(enrollments) == 0 {
@@ -149,38 +117,173 @@ func getS3Portability(c *cli.Context) {
 	}
 }
 
+// queryS3Enrollments - fetch every S3 enrollment for the organization
+func queryS3Enrollments(c *cli.Context) ([]tsapi.S3ExportEnrollmentResponse, error) {
+	var enrollments []tsapi.S3ExportEnrollmentResponse
+	s3PortabilityEndpoint := "/v2/integrations/s3export"
+	req, err := tsBuildHTTPReq(c, "GET", s3PortabilityEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, tsapi.NewAPIError(resp.StatusCode, s3PortabilityEndpoint, body)
+	}
+	if err := json.Unmarshal(body, &enrollments); err != nil {
+		return nil, err
+	}
+	return enrollments, nil
+}
+
+// findS3Enrollment - the existing enrollment for a bucket, as something
+// that can be modified and sent back up
+func findS3Enrollment(c *cli.Context, bucket string) (tsapi.S3ExportEnrollment, error) {
+	enrollments, err := queryS3Enrollments(c)
+	if err != nil {
+		return tsapi.S3ExportEnrollment{}, err
+	}
+	for _, enrollment := range enrollments {
+		if enrollment.S3Bucket == bucket {
+			return tsapi.S3ExportEnrollment{
+				S3Bucket:             enrollment.S3Bucket,
+				IAMRoleARN:           enrollment.IAMRoleARN,
+				IAMRoleARNExternalID: enrollment.IAMRoleARNExternalID,
+				Region:               enrollment.Region,
+				Prefix:               enrollment.Prefix,
+				Enabled:              enrollment.Enabled,
+			}, nil
+		}
+	}
+	return tsapi.S3ExportEnrollment{}, notFound("No S3 enrollment found for bucket %s", bucket)
+}
+
+func updateS3Portability(c *cli.Context) {
+	if c.Args().Get(0) == "" {
+		failUsage(c, "Specify the S3 bucket you want to update after the update command.")
+	}
+	if !c.IsSet("arn") && !c.IsSet("externalID") && !c.IsSet("region") && !c.IsSet("prefix") {
+		failUsage(c, "Nothing to update. Set at least one of --arn, --externalID, --region or --prefix.")
+	}
+
+	enrollment, err := findS3Enrollment(c, c.Args().Get(0))
+	if err != nil {
+		fail(c, err)
+	}
+	if c.IsSet("arn") {
+		enrollment.IAMRoleARN = c.String("arn")
+	}
+	if c.IsSet("externalID") {
+		enrollment.IAMRoleARNExternalID = c.String("externalID")
+	}
+	if c.IsSet("region") {
+		enrollment.Region = c.String("region")
+	}
+	if c.IsSet("prefix") {
+		enrollment.Prefix = c.String("prefix")
+	}
+
+	if c.Bool("check") {
+		if !printS3Checks(enrollment) {
+			os.Exit(exitFindings)
+		}
+		return
+	}
+
+	// The enrollment as it will be is checked, not just the flags given:
+	// a new --region has to suit the stored ARN, say. --skip-validation
+	// lets an enrollment made before a rule existed be updated anyway.
+	if !c.Bool("skip-validation") {
+		if errs := s3EnrollmentProblems(enrollment); len(errs) > 0 {
+			failUsage(c, "Unable to create update request.", errs...)
+		}
+		warnS3Enrollment(enrollment)
+	}
+
+	enrollmentResponse, err := putS3Enrollment(c, enrollment)
+	if err != nil {
+		fail(c, fmt.Errorf("Unable to update S3 Enrollment. %w", err))
+	}
+	printS3Enrollment("Updated S3 Enrollment", enrollmentResponse)
+}
+
+// setS3PortabilityEnabled - pause or resume exports to a bucket, keeping
+// the rest of its enrollment as-is
+func setS3PortabilityEnabled(c *cli.Context, enabled bool) {
+	action := "disable"
+	if enabled {
+		action = "enable"
+	}
+	if c.Args().Get(0) == "" {
+		failUsage(c, fmt.Sprintf("Specify the S3 bucket you want to %s after the %s command.", action, action))
+	}
+
+	enrollment, err := findS3Enrollment(c, c.Args().Get(0))
+	if err != nil {
+		fail(c, err)
+	}
+	if enrollment.Enabled == enabled {
+		fmt.Printf("Exports to %s are already %sd.\n", enrollment.S3Bucket, action)
+		return
+	}
+	enrollment.Enabled = enabled
+
+	if _, err := putS3Enrollment(c, enrollment); err != nil {
+		fail(c, fmt.Errorf("Unable to %s S3 Enrollment. %w", action, err))
+	}
+	fmt.Printf("Exports to %s %sd.\n", enrollment.S3Bucket, action)
+}
+
 func deleteS3Portability(c *cli.Context) {
 	if c.Args().Get(0) == "" {
-		cli.ShowSubcommandHelp(c)
-		fmt.Printf("\nERROR: Specify the S3 bucket you want to delete after the delete command.\n")
-		os.Exit(1)
+		failUsage(c, "Specify the S3 bucket you want to delete after the delete command.")
+	}
+
+	body, err := sendS3Delete(c, c.Args().Get(0))
+	if err != nil {
+		fail(c, fmt.Errorf("Unable to delete S3 Enrollment. %w", err))
 	}
 
-	client := &http.Client{}
+	fmt.Printf("%s\n", body)
+}
+
+// sendS3Delete - remove the enrollment for a bucket, returning the
+// API's response body
+func sendS3Delete(c *cli.Context, bucket string) ([]byte, error) {
 	s3PortabilityEndpoint := "/v2/integrations/s3export"
 
 	integrationToDelete := tsapi.S3ExportDelete{
-		S3Bucket: c.Args().Get(0),
+		S3Bucket: bucket,
 	}
 
 	reqJSON, err := json.Marshal(integrationToDelete)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
 
 	req, err := tsBuildHTTPReq(c, "DELETE", s3PortabilityEndpoint, reqJSON)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
 
-	fmt.Printf("%s\n", body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, tsapi.NewAPIError(resp.StatusCode, s3PortabilityEndpoint, body)
+	}
+	return body, nil
 }
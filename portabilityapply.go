@@ -0,0 +1,225 @@
+// ts - golang ts api client
+// portabilityapply.go: manage S3 exports from a YAML file
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	tsapi "github.com/threatstack/ts/api"
+	"github.com/urfave/cli"
+)
+
+// exportEntry is one S3 export in an exports file. The keys match the
+// ones the API uses.
+type exportEntry struct {
+	S3Bucket             string `yaml:"s3Bucket"`
+	IAMRoleARN           string `yaml:"iamRoleArn"`
+	IAMRoleARNExternalID string `yaml:"iamRoleArnExternalId"`
+	Region               string `yaml:"region"`
+	Prefix               string `yaml:"prefix"`
+	Enabled              *bool  `yaml:"enabled"`
+}
+
+// exportsFile is the exports file layout; a bare list of entries works too
+type exportsFile struct {
+	Exports []exportEntry `yaml:"exports"`
+}
+
+// exportChange is one step of an apply plan
+type exportChange struct {
+	Action     string // create, update or delete
+	Enrollment tsapi.S3ExportEnrollment
+	Diff       []string
+}
+
+func applyPortability(c *cli.Context) {
+	if c.String("file") == "" {
+		failUsage(c, "Specify the exports file with -f.")
+	}
+
+	desired, err := loadExportsFile(c.String("file"))
+	if err != nil {
+		fail(c, fmt.Errorf("Unable to load exports file. %w", err))
+	}
+	// Everything missing from the file gets deleted, so an empty file (or
+	// one whose entries didn't parse) would wipe out every export
+	if len(desired) == 0 && !c.Bool("allow-empty") {
+		fail(c, &cliError{
+			code:    exitUsage,
+			message: fmt.Sprintf("%s has no exports. Pass --allow-empty if you mean to delete every S3 export.", c.String("file")),
+		})
+	}
+
+	current, err := queryS3Enrollments(c)
+	if err != nil {
+		fail(c, err)
+	}
+
+	plan := planPortability(desired, current)
+	printPortabilityPlan(plan)
+	if len(plan) == 0 || c.Bool("dry-run") {
+		return
+	}
+
+	if !c.Bool("yes") && !confirm("Apply these changes?") {
+		fail(c, errors.New("Aborted, no changes made."))
+	}
+
+	failed := 0
+	for _, change := range plan {
+		var err error
+		switch change.Action {
+		case "create", "update":
+			_, err = putS3Enrollment(c, change.Enrollment)
+		case "delete":
+			_, err = sendS3Delete(c, change.Enrollment.S3Bucket)
+		}
+		if err != nil {
+			failed++
+			fmt.Printf("FAILED %s %s: %s\n", change.Action, change.Enrollment.S3Bucket, err)
+			continue
+		}
+		fmt.Printf("OK     %s %s\n", change.Action, change.Enrollment.S3Bucket)
+	}
+	if failed > 0 {
+		fmt.Printf("\n")
+		fail(c, fmt.Errorf("%d of %d changes failed.", failed, len(plan)))
+	}
+}
+
+// loadExportsFile - read and validate the desired enrollments. Entries
+// are enabled unless they say otherwise.
+func loadExportsFile(path string) ([]tsapi.S3ExportEnrollment, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []exportEntry
+	if yamlIsList(data) {
+		err = unmarshalYAMLStrict(data, &entries)
+	} else {
+		var file exportsFile
+		err = unmarshalYAMLStrict(data, &file)
+		entries = file.Exports
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var enrollments []tsapi.S3ExportEnrollment
+	var errs []string
+	seen := make(map[string]bool)
+	for i, entry := range entries {
+		enrollment := tsapi.S3ExportEnrollment{
+			S3Bucket:             entry.S3Bucket,
+			IAMRoleARN:           entry.IAMRoleARN,
+			IAMRoleARNExternalID: entry.IAMRoleARNExternalID,
+			Region:               entry.Region,
+			Prefix:               entry.Prefix,
+			Enabled:              entry.Enabled == nil || *entry.Enabled,
+		}
+		name := entry.S3Bucket
+		if name == "" {
+			name = fmt.Sprintf("entry %d", i+1)
+		}
+		if seen[entry.S3Bucket] {
+			errs = append(errs, fmt.Sprintf("%s: listed more than once", name))
+		}
+		seen[entry.S3Bucket] = true
+		for _, problem := range s3EnrollmentProblems(enrollment) {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, strings.TrimPrefix(problem, "--")))
+		}
+		warnS3Enrollment(enrollment)
+		enrollments = append(enrollments, enrollment)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("\n         * %s", strings.Join(errs, "\n         * "))
+	}
+	return enrollments, nil
+}
+
+// planPortability - the creates, updates and deletes needed to get from
+// the current enrollments to the desired ones
+func planPortability(desired []tsapi.S3ExportEnrollment, current []tsapi.S3ExportEnrollmentResponse) []exportChange {
+	existing := make(map[string]tsapi.S3ExportEnrollmentResponse)
+	for _, enrollment := range current {
+		existing[enrollment.S3Bucket] = enrollment
+	}
+
+	var plan []exportChange
+	wanted := make(map[string]bool)
+	for _, enrollment := range desired {
+		wanted[enrollment.S3Bucket] = true
+		have, ok := existing[enrollment.S3Bucket]
+		if !ok {
+			plan = append(plan, exportChange{Action: "create", Enrollment: enrollment})
+			continue
+		}
+		if diff := diffEnrollment(have, enrollment); len(diff) > 0 {
+			plan = append(plan, exportChange{Action: "update", Enrollment: enrollment, Diff: diff})
+		}
+	}
+	for _, enrollment := range current {
+		if !wanted[enrollment.S3Bucket] {
+			plan = append(plan, exportChange{Action: "delete", Enrollment: tsapi.S3ExportEnrollment{S3Bucket: enrollment.S3Bucket}})
+		}
+	}
+
+	order := map[string]int{"create": 0, "update": 1, "delete": 2}
+	sort.SliceStable(plan, func(i, j int) bool {
+		return order[plan[i].Action] < order[plan[j].Action]
+	})
+	return plan
+}
+
+func diffEnrollment(have tsapi.S3ExportEnrollmentResponse, want tsapi.S3ExportEnrollment) []string {
+	var diff []string
+	fields := []struct{ name, have, want string }{
+		{"iamRoleArn", have.IAMRoleARN, want.IAMRoleARN},
+		{"iamRoleArnExternalId", have.IAMRoleARNExternalID, want.IAMRoleARNExternalID},
+		{"region", have.Region, want.Region},
+		{"prefix", have.Prefix, want.Prefix},
+		{"enabled", fmt.Sprintf("%t", have.Enabled), fmt.Sprintf("%t", want.Enabled)},
+	}
+	for _, field := range fields {
+		if field.have != field.want {
+			diff = append(diff, fmt.Sprintf("%s: %q -> %q", field.name, field.have, field.want))
+		}
+	}
+	return diff
+}
+
+func printPortabilityPlan(plan []exportChange) {
+	if len(plan) == 0 {
+		fmt.Printf("S3 exports already match the file.\n")
+		return
+	}
+	counts := make(map[string]int)
+	for _, change := range plan {
+		counts[change.Action]++
+		switch change.Action {
+		case "create":
+			state := "enabled"
+			if !change.Enrollment.Enabled {
+				state = "disabled"
+			}
+			fmt.Printf("  + create %s (%s, prefix %q, %s)\n", change.Enrollment.S3Bucket, change.Enrollment.Region, change.Enrollment.Prefix, state)
+		case "update":
+			fmt.Printf("  ~ update %s\n", change.Enrollment.S3Bucket)
+			for _, line := range change.Diff {
+				fmt.Printf("      %s\n", line)
+			}
+		case "delete":
+			fmt.Printf("  - delete %s\n", change.Enrollment.S3Bucket)
+		}
+	}
+	fmt.Printf("\nPlan: %d to create, %d to update, %d to delete.\n", counts["create"], counts["update"], counts["delete"])
+}
@@ -0,0 +1,274 @@
+// ts - golang ts api client
+// portabilityread.go: read S3 export data synced to local disk
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	tsapi "github.com/threatstack/ts/api"
+	"github.com/urfave/cli"
+)
+
+// exportRecordTimeFields are the fields checked, in order, for a record's time
+var exportRecordTimeFields = []string{"timestamp", "eventTime", "createdAt", "time"}
+
+// errNotExportData is returned for files that aren't JSON at all, so they can
+// be skipped rather than counted as failures
+var errNotExportData = errors.New("not JSON export data")
+
+// exportRecordFilter is what `ts portability read` keeps
+type exportRecordFilter struct {
+	Type    string
+	AgentID string
+	From    time.Time
+	Until   time.Time
+}
+
+func readPortability(c *cli.Context) {
+	var errs []string
+	if c.Args().Get(0) == "" {
+		errs = append(errs, "Specify the directory (or file) your S3 export was synced to as an argument")
+	}
+	if c.String("format") != "json" && c.String("format") != "ndjson" {
+		errs = append(errs, "Output format must be json or ndjson")
+	}
+	from, until, timeErrs := parseTimeRange(c)
+	errs = append(errs, timeErrs...)
+	if len(errs) > 0 {
+		failUsage(c, "Unable to read export data.", errs...)
+	}
+
+	filter := exportRecordFilter{Type: c.String("type"), AgentID: c.String("agent")}
+	if from != "" {
+		filter.From, _ = time.Parse(apiTimeFormat, from)
+	}
+	if until != "" {
+		filter.Until, _ = time.Parse(apiTimeFormat, until)
+	}
+
+	// The export writes under its Prefix, so with --prefix PATH can be the
+	// whole synced bucket
+	root := filepath.Join(c.Args().Get(0), filepath.FromSlash(c.String("prefix")))
+	if _, err := os.Stat(root); err != nil {
+		fail(c, notFound("No export data at %s", root))
+	}
+	files, err := exportDataFiles(root)
+	if err != nil {
+		fail(c, err)
+	}
+	if len(files) == 0 {
+		fail(c, notFound("No export data (.json, .ndjson, .jsonl or .gz files) found under %s", root))
+	}
+
+	var records []interface{}
+	count := 0
+	failed := 0
+	skipped := 0
+	limit := c.Int("limit")
+	for _, file := range files {
+		rel, _ := filepath.Rel(root, file)
+		err := readExportFile(file, func(record map[string]interface{}) bool {
+			if !filter.matches(rel, record) {
+				return true
+			}
+			count++
+			if c.String("format") == "ndjson" {
+				result, err := filterOutput(c.GlobalString("filter"), c.GlobalString("fields"), record)
+				if err != nil {
+					fail(c, err)
+				}
+				ser, _ := json.Marshal(result)
+				fmt.Println(string(ser))
+			} else {
+				records = append(records, record)
+			}
+			return limit <= 0 || count < limit
+		})
+		if errors.Is(err, errNotExportData) {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s: %s\n", file, err)
+			skipped++
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
+			failed++
+		}
+		if limit > 0 && count >= limit {
+			break
+		}
+	}
+
+	if skipped == len(files) {
+		fail(c, notFound("No export data found under %s", root))
+	}
+	if c.String("format") == "json" {
+		if records == nil {
+			records = []interface{}{}
+		}
+		printJSON(c, records)
+	}
+	if failed > 0 {
+		fail(c, &cliError{
+			code:       exitError,
+			message:    fmt.Sprintf("Unable to read %d of %d files; the output is incomplete", failed, len(files)),
+			incomplete: true,
+		})
+	}
+}
+
+// exportDataFiles - every data file under root, in path order
+func exportDataFiles(root string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		name := strings.ToLower(strings.TrimSuffix(info.Name(), ".gz"))
+		if strings.HasSuffix(info.Name(), ".gz") || strings.HasSuffix(name, ".json") ||
+			strings.HasSuffix(name, ".ndjson") || strings.HasSuffix(name, ".jsonl") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// readExportFile - decode every record in a file, handing each to fn until
+// it returns false. Files may be gzipped, and may hold one JSON document,
+// a JSON array, or newline-delimited JSON. Anything else (an archive that
+// happens to end in .gz, say) is errNotExportData.
+func readExportFile(path string, fn func(map[string]interface{}) bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err == gzip.ErrHeader {
+			return errNotExportData
+		} else if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	buffered := bufio.NewReader(r)
+	for {
+		b, err := buffered.Peek(1)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if b[0] == '{' || b[0] == '[' {
+			break
+		}
+		if !unicode.IsSpace(rune(b[0])) {
+			return errNotExportData
+		}
+		buffered.Discard(1)
+	}
+
+	decoder := json.NewDecoder(buffered)
+	decoder.UseNumber()
+	for {
+		var value interface{}
+		if err := decoder.Decode(&value); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		}
+		for _, item := range items {
+			record, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if !fn(record) {
+				return nil
+			}
+		}
+	}
+}
+
+// matches - whether a record passes the filter. --type matches either a
+// directory in the record's path (relative to the export's prefix) or its
+// type/eventType field.
+func (f exportRecordFilter) matches(path string, record map[string]interface{}) bool {
+	if f.Type != "" && !exportRecordHasType(path, record, f.Type) {
+		return false
+	}
+	if f.AgentID != "" && fmt.Sprint(record["agentId"]) != f.AgentID {
+		return false
+	}
+	if !f.From.IsZero() || !f.Until.IsZero() {
+		t, ok := exportRecordTime(record)
+		if !ok {
+			return false
+		}
+		if !f.From.IsZero() && t.Before(f.From) {
+			return false
+		}
+		if !f.Until.IsZero() && !t.Before(f.Until) {
+			return false
+		}
+	}
+	return true
+}
+
+func exportRecordHasType(path string, record map[string]interface{}, recordType string) bool {
+	for _, key := range []string{"type", "eventType"} {
+		if value, ok := record[key].(string); ok && strings.EqualFold(value, recordType) {
+			return true
+		}
+	}
+	for _, segment := range strings.Split(filepath.ToSlash(filepath.Dir(path)), "/") {
+		if strings.EqualFold(segment, recordType) {
+			return true
+		}
+	}
+	return false
+}
+
+// exportRecordTime - a record's time, from epoch millis or RFC 3339
+func exportRecordTime(record map[string]interface{}) (time.Time, bool) {
+	for _, field := range exportRecordTimeFields {
+		value, ok := record[field]
+		if !ok {
+			continue
+		}
+		ser, err := json.Marshal(value)
+		if err != nil {
+			continue
+		}
+		var t tsapi.EventTimestamp
+		if err := json.Unmarshal(ser, &t); err == nil && !t.IsZero() {
+			return t.Time, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,91 @@
+// ts - golang ts api client
+// prompt.go: questions and passphrases on the terminal
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// stdin is shared by every prompt, so piped answers aren't lost to one
+// reader's buffer
+var stdin = bufio.NewReader(os.Stdin)
+
+// readLine - prompt on stderr and read a line from stdin
+func readLine(prompt string) (string, error) {
+	fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	answers := make(chan string, 1)
+	errs := make(chan error, 1)
+	go func() {
+		answer, err := stdin.ReadString('\n')
+		if err != nil && answer == "" {
+			errs <- err
+			return
+		}
+		answers <- strings.TrimSpace(answer)
+	}()
+	select {
+	case answer := <-answers:
+		return answer, nil
+	case err := <-errs:
+		return "", err
+	case <-appContext.Done():
+		fmt.Fprintf(os.Stderr, "\n")
+		return "", appContext.Err()
+	}
+}
+
+// readSecret - like readLine, but without echoing what's typed when stdin
+// is a terminal
+func readSecret(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return readLine(prompt)
+	}
+	// ReadPassword turns echo back on when it returns, but Ctrl-C doesn't
+	// make it return
+	state, err := term.GetState(fd)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	defer fmt.Fprintf(os.Stderr, "\n")
+	answers := make(chan string, 1)
+	errs := make(chan error, 1)
+	go func() {
+		answer, err := term.ReadPassword(fd)
+		if err != nil {
+			errs <- err
+			return
+		}
+		answers <- strings.TrimSpace(string(answer))
+	}()
+	select {
+	case answer := <-answers:
+		return answer, nil
+	case err := <-errs:
+		return "", err
+	case <-appContext.Done():
+		term.Restore(fd, state)
+		return "", appContext.Err()
+	}
+}
+
+// confirm - ask a yes/no question on the terminal. Ctrl-C at the prompt
+// exits.
+func confirm(prompt string) bool {
+	answer, err := readLine(prompt + " [y/N]")
+	if interrupted(err) {
+		os.Exit(exitInterrupted)
+	}
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes"
+}
@@ -7,12 +7,15 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"sort"
+	"strings"
 
 	tsapi "github.com/threatstack/ts/api"
 	"github.com/urfave/cli"
@@ -20,57 +23,279 @@ import (
 
 func raw(c *cli.Context) {
 	if c.Args().Get(0) == "" {
-		cli.ShowSubcommandHelp(c)
-		fmt.Printf("ERROR: Specify endpoint as first argument\n")
-		os.Exit(1)
+		failUsage(c, "Specify endpoint as first argument")
 	}
 
-	if c.String("request") != "GET" && c.String("data") == "" {
-		cli.ShowSubcommandHelp(c)
-		fmt.Printf("ERROR: You specified a Write API call (not GET) and didn't specify a payload\n")
-		os.Exit(1)
+	payload, err := rawPayload(c.String("data"))
+	if err != nil {
+		fail(c, fmt.Errorf("Unable to read payload: %w", err))
 	}
 
-	if c.String("request") == "GET" && c.String("data") != "" {
-		cli.ShowSubcommandHelp(c)
-		fmt.Printf("ERROR: You specified a GET request... but you specified data too. Huh?\n")
-		os.Exit(1)
+	if c.String("request") == "GET" && len(payload) > 0 {
+		failUsage(c, "You specified a GET request... but you specified data too. Huh?")
 	}
 
-	payload := []byte(c.String("data"))
+	headers, err := rawHeaders(c.StringSlice("header"))
+	if err != nil {
+		failUsage(c, err.Error())
+	}
+
+	if c.Bool("as-curl") {
+		req, err := tsBuildHTTPReq(c, c.String("request"), c.Args().Get(0), payload)
+		if err != nil {
+			fail(c, err)
+		}
+		for name, values := range headers {
+			req.Header[name] = values
+		}
+		fmt.Printf("%s\n", curlCommand(req, payload))
+		return
+	}
 
-	client := &http.Client{}
+	if c.Bool("paginate") {
+		var errs []string
+		if c.String("request") != "GET" {
+			errs = append(errs, "--paginate only works with GET requests")
+		}
+		if c.Bool("include") {
+			errs = append(errs, "--paginate can't be combined with --include")
+		}
+		if c.String("paginate-format") != "merge" && c.String("paginate-format") != "ndjson" {
+			errs = append(errs, "--paginate-format must be merge or ndjson")
+		}
+		if len(errs) > 0 {
+			failUsage(c, "Unable to paginate.", errs...)
+		}
+		rawPaginate(c, c.Args().Get(0), headers)
+		return
+	}
+
+	resp, body, err := sendRaw(c, c.String("request"), c.Args().Get(0), payload, headers)
+	if err != nil {
+		fail(c, err)
+	}
+
+	if c.Bool("include") {
+		fmt.Printf("%s %s\n", resp.Proto, resp.Status)
+		var names []string
+		for name := range resp.Header {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, value := range resp.Header[name] {
+				fmt.Printf("%s: %s\n", name, value)
+			}
+		}
+		fmt.Printf("\n")
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		if len(body) > 0 {
+			fmt.Printf("%s\n", body)
+		}
+		return
+	}
+	rawFailure(c, c.Args().Get(0), resp, body)
+}
+
+// sendRaw - send one signed request, returning the response and its body
+func sendRaw(c *cli.Context, method string, endpoint string, payload []byte, headers http.Header) (*http.Response, []byte, error) {
 	if c.Bool("debug") {
-		fmt.Printf("* HTTP %s: %s\n", c.String("request"), c.GlobalString("endpoint")+c.Args().Get(0))
-		if c.String("data") != "" {
-			fmt.Printf("* Payload: %s\n", c.String("data"))
+		fmt.Printf("* HTTP %s: %s\n", method, c.GlobalString("endpoint")+endpoint)
+		if len(payload) > 0 {
+			fmt.Printf("* Payload: %s\n", payload)
 		}
 	}
-	req, err := tsBuildHTTPReq(c, c.String("request"), c.Args().Get(0), payload)
+	req, err := tsBuildHTTPReq(c, method, endpoint, payload)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, nil, err
+	}
+	// Extra headers go on after signing. Hawk covers the method, URL,
+	// payload and content type, and rawHeaders refuses the headers that
+	// would change those, so the rest don't affect the signature.
+	for name, values := range headers {
+		req.Header[name] = values
 	}
-	resp, err := client.Do(req)
+	resp, err := apiClient.Do(req)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, nil, err
 	}
+	return resp, body, nil
+}
 
-	if resp.StatusCode == 200 {
-		fmt.Printf("%s\n", body)
-	} else {
+// rawFailure - report a non-2xx response and exit with the status's exit code
+func rawFailure(c *cli.Context, endpoint string, resp *http.Response, body []byte) {
+	apiErr := tsapi.NewAPIError(resp.StatusCode, endpoint, body)
+	if c.GlobalString("output") == "json" {
+		fail(c, apiErr)
+	}
+	if !c.Bool("include") {
 		fmt.Printf("The API responded with an HTTP/%d.\n", resp.StatusCode)
-		var errResponse tsapi.Error
-		if err := json.Unmarshal(body, &errResponse); err != nil {
-			log.Fatalln(err)
+	}
+	var errResponse tsapi.Error
+	if err := json.Unmarshal(body, &errResponse); err != nil || len(errResponse.Errors) == 0 {
+		// Not the API's usual error shape (a proxy error page, say), so
+		// show it as-is.
+		if len(body) > 0 {
+			fmt.Printf("%s\n", body)
+		}
+	} else {
+		for _, v := range errResponse.Errors {
+			fmt.Printf("* %s\n", v)
+		}
+	}
+	os.Exit(exitCode(apiErr))
+}
+
+// rawPaginate - follow the top-level "token" in each response until the
+// last page. Pages are either merged into one response, with each list
+// field concatenated, or printed one per line as they arrive.
+func rawPaginate(c *cli.Context, endpoint string, headers http.Header) {
+	var merged map[string]interface{}
+	seen := make(map[string]bool)
+	pages := 0
+	for {
+		resp, body, err := sendRaw(c, "GET", endpoint, nil, headers)
+		if err != nil {
+			if !interrupted(err) || pages == 0 {
+				fail(c, err)
+			}
+			// Ctrl-C: print the pages merged so far (ndjson pages are
+			// already out) and flag the output as incomplete
+			if merged != nil {
+				printMergedPages(c, merged)
+			}
+			fail(c, incompleteOutput())
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			if merged != nil {
+				fmt.Fprintf(os.Stderr, "Stopped paginating at %s\n", endpoint)
+			}
+			rawFailure(c, endpoint, resp, body)
+		}
+
+		var page map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&page); err != nil {
+			// Not a JSON object, so there's nothing to follow.
+			fmt.Printf("%s\n", body)
+			return
+		}
+
+		if c.String("paginate-format") == "ndjson" {
+			ser, err := json.Marshal(page)
+			if err != nil {
+				fail(c, err)
+			}
+			fmt.Printf("%s\n", ser)
+		} else if merged == nil {
+			merged = page
 		} else {
-			for _, v := range errResponse.Errors {
-				fmt.Printf("* %s\n", v)
+			for key, value := range page {
+				list, isList := value.([]interface{})
+				existing, wasList := merged[key].([]interface{})
+				if isList && wasList {
+					merged[key] = append(existing, list...)
+				}
 			}
 		}
+
+		pages++
+
+		token, _ := page["token"].(string)
+		if token == "" || seen[token] {
+			break
+		}
+		seen[token] = true
+
+		next, err := withQueryParam(endpoint, "token", token)
+		if err != nil {
+			fail(c, err)
+		}
+		endpoint = next
+	}
+
+	if merged != nil {
+		printMergedPages(c, merged)
+	}
+}
+
+func printMergedPages(c *cli.Context, merged map[string]interface{}) {
+	delete(merged, "token")
+	ser, err := json.Marshal(merged)
+	if err != nil {
+		fail(c, err)
+	}
+	fmt.Printf("%s\n", ser)
+}
+
+// withQueryParam - set a query parameter on an endpoint, keeping any
+// query string it already has
+func withQueryParam(endpoint string, key string, value string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set(key, value)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// rawPayload - the request body from --data: inline JSON, @FILE to read
+// a file, or - to read stdin
+func rawPayload(data string) ([]byte, error) {
+	switch {
+	case data == "-":
+		return ioutil.ReadAll(os.Stdin)
+	case strings.HasPrefix(data, "@"):
+		return ioutil.ReadFile(strings.TrimPrefix(data, "@"))
+	}
+	return []byte(data), nil
+}
+
+// rawStdinArgs - turn "ts raw ... -d -" into "--data=-". urfave/cli moves
+// flags ahead of arguments so they can follow the endpoint, and would take
+// a bare "-" for an argument rather than --data's value.
+func rawStdinArgs(args []string) []string {
+	out := append([]string(nil), args...)
+	inRaw := false
+	for i := 1; i < len(out); i++ {
+		switch {
+		case out[i] == "--":
+			return out
+		case !inRaw:
+			inRaw = out[i] == "raw"
+		case i+1 < len(out) && out[i+1] == "-" && (out[i] == "-d" || strings.TrimLeft(out[i], "-") == "data"):
+			out = append(out[:i], append([]string{"--data=-"}, out[i+2:]...)...)
+		}
+	}
+	return out
+}
+
+// rawHeaders - parse "Name: value" header flags
+func rawHeaders(flags []string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, flag := range flags {
+		parts := strings.SplitN(flag, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("Headers look like 'Name: value', got %q", flag)
+		}
+		name := http.CanonicalHeaderKey(strings.TrimSpace(parts[0]))
+		if name == "Authorization" {
+			return nil, fmt.Errorf("The Authorization header is set by request signing and can't be overridden")
+		}
+		if name == "Content-Type" {
+			return nil, fmt.Errorf("The Content-Type header is covered by the request signature and can't be overridden")
+		}
+		headers.Add(name, strings.TrimSpace(parts[1]))
 	}
+	return headers, nil
 }
@@ -0,0 +1,227 @@
+// ts - golang ts api client
+// s3validate.go: local checks for S3 portability settings
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+
+	tsapi "github.com/threatstack/ts/api"
+)
+
+// awsRegions are the region names S3 exports can be sent to, as of this
+// release. AWS adds regions, so one that isn't listed is only a warning.
+var awsRegions = make(map[string]bool)
+
+func init() {
+	for _, region := range strings.Fields(`
+		us-east-1 us-east-2 us-west-1 us-west-2
+		af-south-1
+		ap-east-1 ap-east-2 ap-south-1 ap-south-2
+		ap-northeast-1 ap-northeast-2 ap-northeast-3
+		ap-southeast-1 ap-southeast-2 ap-southeast-3 ap-southeast-4 ap-southeast-5 ap-southeast-7
+		ca-central-1 ca-west-1
+		eu-central-1 eu-central-2 eu-north-1 eu-south-1 eu-south-2 eu-west-1 eu-west-2 eu-west-3
+		il-central-1 me-central-1 me-south-1
+		mx-central-1 sa-east-1
+		us-gov-east-1 us-gov-west-1
+		cn-north-1 cn-northwest-1`) {
+		awsRegions[region] = true
+	}
+}
+
+var (
+	s3BucketName  = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	iamRoleARN    = regexp.MustCompile(`^arn:(aws|aws-cn|aws-us-gov):iam::(\d{12}):role/(.+)$`)
+	iamRolePath   = regexp.MustCompile(`^([\x21-\x7e]+/)*[\w+=,.@-]{1,64}$`)
+	iamExternalID = regexp.MustCompile(`^[\w+=,.@:/-]+$`)
+	awsRegionName = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+)
+
+// s3FieldCheck is the result of checking one enrollment field
+type s3FieldCheck struct {
+	Flag    string
+	Value   string
+	Problem string
+	Warning string
+}
+
+// checkS3Enrollment - check every field of an enrollment, so all of the
+// problems can be reported at once
+func checkS3Enrollment(enrollment tsapi.S3ExportEnrollment) []s3FieldCheck {
+	return []s3FieldCheck{
+		{"s3bucket", enrollment.S3Bucket, checkS3BucketName(enrollment.S3Bucket), ""},
+		{"arn", enrollment.IAMRoleARN, checkIAMRoleARN(enrollment.IAMRoleARN, enrollment.Region), ""},
+		{"externalID", enrollment.IAMRoleARNExternalID, checkIAMExternalID(enrollment.IAMRoleARNExternalID), ""},
+		{"region", enrollment.Region, checkAWSRegion(enrollment.Region), awsRegionWarning(enrollment.Region)},
+		{"prefix", enrollment.Prefix, checkS3Prefix(enrollment.Prefix), ""},
+	}
+}
+
+// s3EnrollmentProblems - just the failed checks, worded for an error list
+func s3EnrollmentProblems(enrollment tsapi.S3ExportEnrollment) []string {
+	var problems []string
+	for _, check := range checkS3Enrollment(enrollment) {
+		if check.Problem != "" {
+			problems = append(problems, fmt.Sprintf("--%s: %s", check.Flag, check.Problem))
+		}
+	}
+	return problems
+}
+
+// warnS3Enrollment - print checks that passed with a warning to stderr
+func warnS3Enrollment(enrollment tsapi.S3ExportEnrollment) {
+	for _, check := range checkS3Enrollment(enrollment) {
+		if check.Problem == "" && check.Warning != "" {
+			fmt.Fprintf(os.Stderr, "Warning: %s --%s: %s\n", enrollment.S3Bucket, check.Flag, check.Warning)
+		}
+	}
+}
+
+// printS3Checks - the --check report; returns whether everything passed
+func printS3Checks(enrollment tsapi.S3ExportEnrollment) bool {
+	ok := true
+	for _, check := range checkS3Enrollment(enrollment) {
+		value := check.Value
+		if value == "" {
+			value = "(empty)"
+		}
+		if check.Problem == "" && check.Warning != "" {
+			fmt.Printf("  warn  --%-11s %s\n", check.Flag, value)
+			fmt.Printf("        %s\n", check.Warning)
+			continue
+		}
+		if check.Problem == "" {
+			fmt.Printf("  ok    --%-11s %s\n", check.Flag, value)
+			continue
+		}
+		ok = false
+		fmt.Printf("  FAIL  --%-11s %s\n", check.Flag, value)
+		fmt.Printf("        %s\n", check.Problem)
+	}
+	return ok
+}
+
+// checkS3BucketName applies the S3 bucket naming rules for new buckets
+func checkS3BucketName(bucket string) string {
+	switch {
+	case bucket == "":
+		return "Missing S3 Bucket Name"
+	case len(bucket) < 3 || len(bucket) > 63:
+		return fmt.Sprintf("bucket names must be 3 to 63 characters long, this is %d", len(bucket))
+	case strings.ToLower(bucket) != bucket:
+		return "bucket names can't contain uppercase letters"
+	case !s3BucketName.MatchString(bucket):
+		return "bucket names may only contain lowercase letters, numbers, dots and hyphens, and must begin and end with a letter or number"
+	case strings.Contains(bucket, ".."):
+		return "bucket names can't contain two adjacent dots"
+	case strings.Contains(bucket, ".-") || strings.Contains(bucket, "-."):
+		return "bucket names can't have a dot next to a hyphen"
+	case net.ParseIP(bucket) != nil:
+		return "bucket names can't be formatted as an IP address"
+	case strings.HasPrefix(bucket, "xn--") || strings.HasPrefix(bucket, "sthree-"):
+		return "bucket names can't start with 'xn--' or 'sthree-'"
+	case strings.HasSuffix(bucket, "-s3alias") || strings.HasSuffix(bucket, "--ol-s3"):
+		return "bucket names can't end with '-s3alias' or '--ol-s3'"
+	}
+	return ""
+}
+
+// checkIAMRoleARN wants arn:PARTITION:iam::ACCOUNT:role/[PATH/]NAME, in the
+// partition the region belongs to
+func checkIAMRoleARN(arn string, region string) string {
+	if arn == "" {
+		return "Missing IAM Role ARN"
+	}
+	match := iamRoleARN.FindStringSubmatch(arn)
+	if match == nil {
+		if strings.HasPrefix(arn, "arn:") && strings.Contains(arn, ":user/") {
+			return "this is an IAM user ARN; exports need a role (arn:aws:iam::ACCOUNT:role/NAME)"
+		}
+		return "expected an IAM role ARN like arn:aws:iam::123456789012:role/NAME"
+	}
+	if !iamRolePath.MatchString(match[3]) {
+		return "role names are 1 to 64 characters of letters, numbers and +=,.@_-"
+	}
+	if region != "" {
+		partition := "aws"
+		if strings.HasPrefix(region, "cn-") {
+			partition = "aws-cn"
+		} else if strings.HasPrefix(region, "us-gov-") {
+			partition = "aws-us-gov"
+		}
+		if match[1] != partition {
+			return fmt.Sprintf("the ARN is in the %s partition, but %s is in %s", match[1], region, partition)
+		}
+	}
+	return ""
+}
+
+func checkIAMExternalID(externalID string) string {
+	if externalID == "" {
+		return ""
+	}
+	if len(externalID) < 2 || len(externalID) > 1224 || !iamExternalID.MatchString(externalID) {
+		return "external IDs are 2 to 1224 characters of letters, numbers and +=,.@:/-_"
+	}
+	return ""
+}
+
+func checkAWSRegion(region string) string {
+	if region == "" {
+		return "Missing AWS Region"
+	}
+	if !awsRegions[region] {
+		if lower := strings.ToLower(strings.TrimSpace(region)); awsRegions[lower] {
+			return fmt.Sprintf("region names are lowercase, did you mean %s?", lower)
+		}
+		if !awsRegionName.MatchString(region) {
+			return fmt.Sprintf("%q doesn't look like an AWS region name (e.g. us-east-1)", region)
+		}
+	}
+	return ""
+}
+
+// awsRegionWarning - a well-formed region that isn't in awsRegions may be
+// newer than this release, or a typo
+func awsRegionWarning(region string) string {
+	if region == "" || awsRegions[region] || !awsRegionName.MatchString(region) {
+		return ""
+	}
+	return fmt.Sprintf("%q is not an AWS region ts knows about; check the spelling", region)
+}
+
+// checkS3Prefix - the prefix is optional, but has to be a usable key prefix
+func checkS3Prefix(prefix string) string {
+	switch {
+	case prefix == "":
+		return ""
+	case len(prefix) > 512:
+		return "prefixes can't be longer than 512 bytes"
+	case strings.HasPrefix(prefix, "/"):
+		return "prefixes shouldn't start with '/', S3 would create an empty top-level folder"
+	case strings.Contains(prefix, "//"):
+		return "prefixes can't contain empty path segments ('//')"
+	case strings.Contains(prefix, `\`):
+		return "use '/' to separate prefix segments, not '\\'"
+	}
+	for _, segment := range strings.Split(prefix, "/") {
+		if segment == "." || segment == ".." {
+			return "prefixes can't contain '.' or '..' segments"
+		}
+	}
+	for _, r := range prefix {
+		if unicode.IsControl(r) {
+			return "prefixes can't contain control characters"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,241 @@
+// ts - golang ts api client
+// syslog.go: format alerts as CEF, LEEF or RFC 5424 and ship them over syslog
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	tsapi "github.com/threatstack/ts/api"
+)
+
+const (
+	// syslogFacility is local0, the usual home for security tooling
+	syslogFacility = 16
+	// syslogEnterpriseID is used in RFC 5424 structured data IDs
+	syslogEnterpriseID = 32473
+
+	cefVendor  = "Threat Stack"
+	cefProduct = "Threat Stack Cloud Security Platform"
+	cefVersion = "2.0"
+)
+
+// syslogSink sends formatted alerts to a syslog collector
+type syslogSink struct {
+	network  string
+	address  string
+	format   string
+	hostname string
+	conn     net.Conn
+}
+
+// newSyslogSink - parse a destination like udp://host:514 and check the format
+func newSyslogSink(destination string, format string) (*syslogSink, error) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid syslog destination: %s", destination)
+	}
+	if u.Scheme != "udp" && u.Scheme != "tcp" {
+		return nil, fmt.Errorf("Invalid syslog destination: %s (use udp://host:port or tcp://host:port)", destination)
+	}
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), "514")
+	}
+
+	switch format {
+	case "cef", "leef", "rfc5424":
+	default:
+		return nil, fmt.Errorf("Invalid syslog format: %s (choose cef, leef, or rfc5424)", format)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+
+	return &syslogSink{
+		network:  u.Scheme,
+		address:  address,
+		format:   format,
+		hostname: hostname,
+	}, nil
+}
+
+// Send formats and writes a single alert, reconnecting once if the
+// connection has gone away.
+func (s *syslogSink) Send(alert tsapi.Alert) error {
+	var message string
+	switch s.format {
+	case "cef":
+		message = syslogHeader3164(alert, s.hostname) + formatCEF(alert)
+	case "leef":
+		message = syslogHeader3164(alert, s.hostname) + formatLEEF(alert)
+	default:
+		message = formatRFC5424(alert, s.hostname)
+	}
+	if s.network == "tcp" {
+		message = message + "\n"
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			s.conn, err = net.DialTimeout(s.network, s.address, 10*time.Second)
+			if err != nil {
+				continue
+			}
+		}
+		if _, err = s.conn.Write([]byte(message)); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+// Close closes the connection to the collector.
+func (s *syslogSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// syslogSeverity - map a Threat Stack severity (1 is highest) to a syslog one
+func syslogSeverity(severity int) int {
+	switch severity {
+	case 1:
+		return 3 // error
+	case 2:
+		return 4 // warning
+	default:
+		return 5 // notice
+	}
+}
+
+func syslogPriority(alert tsapi.Alert) int {
+	return syslogFacility*8 + syslogSeverity(alert.Severity)
+}
+
+// syslogHeader3164 is the BSD-style header most SIEMs expect in front of
+// CEF and LEEF payloads.
+func syslogHeader3164(alert tsapi.Alert, hostname string) string {
+	return fmt.Sprintf("<%d>%s %s ", syslogPriority(alert), alertCreatedAt(alert).UTC().Format(time.Stamp), hostname)
+}
+
+// formatRFC5424 - an RFC 5424 message with the alert fields as structured data
+func formatRFC5424(alert tsapi.Alert, hostname string) string {
+	params := []struct{ key, value string }{
+		{"id", alert.ID},
+		{"severity", strconv.Itoa(alert.Severity)},
+		{"agentId", alert.AgentID},
+		{"ruleId", alert.RuleID},
+		{"rulesetId", alert.RulesetID},
+		{"dataSource", alert.DataSource},
+	}
+	var sd strings.Builder
+	fmt.Fprintf(&sd, "[alert@%d", syslogEnterpriseID)
+	for _, p := range params {
+		if p.value == "" {
+			continue
+		}
+		fmt.Fprintf(&sd, " %s=\"%s\"", p.key, escapeSDParam(p.value))
+	}
+	sd.WriteString("]")
+
+	return fmt.Sprintf("<%d>1 %s %s ts - alert %s %s",
+		syslogPriority(alert),
+		alertCreatedAt(alert).UTC().Format(time.RFC3339Nano),
+		hostname,
+		sd.String(),
+		strings.NewReplacer("\n", " ", "\r", " ").Replace(alert.Title))
+}
+
+// escapeSDParam escapes a structured data value per RFC 5424 section 6.3.3.
+// Newlines would end the message early on a TCP connection, so they go too.
+func escapeSDParam(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`, "\n", " ", "\r", " ").Replace(value)
+}
+
+// cefSeverity - map a Threat Stack severity onto CEF's 0-10 scale
+func cefSeverity(severity int) int {
+	switch severity {
+	case 1:
+		return 8
+	case 2:
+		return 5
+	default:
+		return 3
+	}
+}
+
+// formatCEF - an ArcSight Common Event Format record for an alert
+func formatCEF(alert tsapi.Alert) string {
+	header := strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	ext := strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+
+	extensions := []struct{ key, value string }{
+		{"rt", strconv.FormatInt(alertCreatedAt(alert).UnixMilli(), 10)},
+		{"externalId", alert.ID},
+		{"cat", alert.DataSource},
+		{"cs1Label", "agentId"},
+		{"cs1", alert.AgentID},
+		{"cs2Label", "ruleId"},
+		{"cs2", alert.RuleID},
+		{"cs3Label", "rulesetId"},
+		{"cs3", alert.RulesetID},
+		{"msg", alert.Title},
+	}
+	var pairs []string
+	for _, e := range extensions {
+		pairs = append(pairs, e.key+"="+ext.Replace(e.value))
+	}
+
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+		header.Replace(cefVendor),
+		header.Replace(cefProduct),
+		header.Replace(cefVersion),
+		header.Replace(alert.RuleID),
+		header.Replace(alert.Title),
+		cefSeverity(alert.Severity),
+		strings.Join(pairs, " "))
+}
+
+// formatLEEF - an IBM QRadar LEEF 1.0 record for an alert
+func formatLEEF(alert tsapi.Alert) string {
+	header := strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	value := strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+	attributes := []struct{ key, value string }{
+		{"devTime", alertCreatedAt(alert).UTC().Format("Jan 02 2006 15:04:05")},
+		{"sev", strconv.Itoa(cefSeverity(alert.Severity))},
+		{"cat", alert.DataSource},
+		{"alertId", alert.ID},
+		{"agentId", alert.AgentID},
+		{"ruleId", alert.RuleID},
+		{"rulesetId", alert.RulesetID},
+		{"title", alert.Title},
+	}
+	var pairs []string
+	for _, a := range attributes {
+		pairs = append(pairs, a.key+"="+value.Replace(a.value))
+	}
+
+	return fmt.Sprintf("LEEF:1.0|%s|%s|%s|%s|%s",
+		header.Replace(cefVendor),
+		header.Replace(cefProduct),
+		header.Replace(cefVersion),
+		header.Replace(alert.RuleID),
+		strings.Join(pairs, "\t"))
+}
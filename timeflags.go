@@ -0,0 +1,125 @@
+// ts - golang ts api client
+// timeflags.go: shared parsing for --from/--until style date filters
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// apiTimeFormat is the ISO-8601 layout the API expects for date filters
+const apiTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// relativeTime matches durations like 30m, 24h, 7d or 2w
+var relativeTime = regexp.MustCompile(`^(\d+)([smhdw])$`)
+
+// allDigits matches numbers, which are only taken as epoch milliseconds when
+// they have 13 digits (2001 to 2286); 20220301 or epoch seconds are too easy
+// to get silently wrong
+var allDigits = regexp.MustCompile(`^\d+$`)
+
+// parseTimeFlag - turn a user-supplied time into a time.Time. Accepts RFC3339
+// (with or without an offset), plain dates, epoch milliseconds, durations
+// relative to now (24h, 7d, 2w) and the words now, today and yesterday.
+// Dates and times without an offset are in now's time zone, like today and
+// yesterday.
+func parseTimeFlag(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	switch strings.ToLower(value) {
+	case "now":
+		return now, nil
+	case "today":
+		y, m, d := now.Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, now.Location()), nil
+	case "yesterday":
+		y, m, d := now.Date()
+		return time.Date(y, m, d-1, 0, 0, 0, 0, now.Location()), nil
+	}
+
+	if match := relativeTime.FindStringSubmatch(value); match != nil {
+		n, err := strconv.Atoi(match[1])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid relative time %q", value)
+		}
+		unit := map[string]time.Duration{
+			"s": time.Second,
+			"m": time.Minute,
+			"h": time.Hour,
+			"d": 24 * time.Hour,
+			"w": 7 * 24 * time.Hour,
+		}[match[2]]
+		return now.Add(-time.Duration(n) * unit), nil
+	}
+
+	if allDigits.MatchString(value) {
+		millis, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || len(value) != 13 {
+			return time.Time{}, fmt.Errorf("%q is ambiguous (epoch milliseconds have 13 digits; write dates as 2006-01-02)", value)
+		}
+		return time.UnixMilli(millis), nil
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to parse time %q (try RFC3339, epoch millis, 24h, 7d, or yesterday)", value)
+}
+
+// formatAPITime - normalize a time to the format the API expects
+func formatAPITime(t time.Time) string {
+	return t.UTC().Format(apiTimeFormat)
+}
+
+// parseTimeRange - read the --from and --until flags and return them in API
+// format. Either may be empty if the flag wasn't set. Problems are returned
+// as a list of messages suitable for the usual validation output.
+func parseTimeRange(c *cli.Context) (string, string, []string) {
+	var from, until time.Time
+	var errs []string
+	now := time.Now()
+
+	if c.String("from") != "" {
+		t, err := parseTimeFlag(c.String("from"), now)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("Invalid \"from\" time: %s", err))
+		}
+		from = t
+	}
+
+	if c.String("until") != "" {
+		t, err := parseTimeFlag(c.String("until"), now)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("Invalid \"until\" time: %s", err))
+		}
+		until = t
+	}
+
+	if len(errs) > 0 {
+		return "", "", errs
+	}
+
+	if !from.IsZero() && !until.IsZero() && !from.Before(until) {
+		errs = append(errs, fmt.Sprintf("\"from\" (%s) must be before \"until\" (%s)", formatAPITime(from), formatAPITime(until)))
+		return "", "", errs
+	}
+
+	var fromString, untilString string
+	if !from.IsZero() {
+		fromString = formatAPITime(from)
+	}
+	if !until.IsZero() {
+		untilString = formatAPITime(until)
+	}
+	return fromString, untilString, nil
+}
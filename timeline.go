@@ -0,0 +1,227 @@
+// ts - golang ts api client
+// timeline.go: render an alert's contributing events as a timeline and process tree
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	tsapi "github.com/threatstack/ts/api"
+)
+
+// processNode is one process in the rendered tree
+type processNode struct {
+	event    tsapi.AlertEvent
+	files    []string
+	children []*processNode
+}
+
+// writeEventTimeline - print events in chronological order, followed by a
+// parent/child tree of any processes they ran
+func writeEventTimeline(out io.Writer, events []tsapi.AlertEvent) {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp.Time)
+	})
+
+	fmt.Fprintf(out, "Timeline (%d events)\n", len(events))
+	fmt.Fprintf(out, "----------------------------------------------------------------------\n")
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, event := range events {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", formatEventTime(event), eventKind(event), eventUser(event), eventSummary(event))
+	}
+	w.Flush()
+
+	roots := buildProcessTree(events)
+	if len(roots) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "\nProcess tree\n")
+	fmt.Fprintf(out, "----------------------------------------------------------------------\n")
+	for _, root := range roots {
+		writeProcessNode(out, root, "", "")
+	}
+}
+
+// buildProcessTree - link process events together by pid/ppid. Processes
+// whose parent isn't in the event list become roots. Events are expected in
+// chronological order.
+func buildProcessTree(events []tsapi.AlertEvent) []*processNode {
+	// A PID can exec more than once (sh -c 'exec ...', env, sudo); each
+	// exec gets its own node, shown under the one it replaced.
+	nodes := make(map[int][]*processNode)
+	var order []*processNode
+	parents := make(map[*processNode]*processNode)
+	for _, event := range events {
+		if !event.IsProcess() {
+			continue
+		}
+		node := &processNode{event: event}
+		if previous := nodes[event.PID]; len(previous) > 0 {
+			parents[node] = previous[len(previous)-1]
+		}
+		nodes[event.PID] = append(nodes[event.PID], node)
+		order = append(order, node)
+	}
+
+	// A PID's first exec goes under whatever its parent was running then
+	for _, node := range order {
+		if _, ok := parents[node]; ok || node.event.PPID == node.event.PID {
+			continue
+		}
+		if parent := processAt(nodes[node.event.PPID], node.event); parent != nil {
+			parents[node] = parent
+		}
+	}
+
+	// Attach files touched by each process, whether or not that event was
+	// the exec itself.
+	for _, event := range events {
+		node := processAt(nodes[event.PID], event)
+		if node == nil || event.Filename == "" {
+			continue
+		}
+		node.files = append(node.files, event.Filename)
+	}
+
+	// Recycled PIDs can make a pid/ppid chain loop back on itself. The
+	// first process found on a loop becomes a root, so nothing is dropped.
+	for _, node := range order {
+		seen := map[*processNode]bool{node: true}
+		for parent := parents[node]; parent != nil; parent = parents[parent] {
+			if parent == node {
+				delete(parents, node)
+				break
+			}
+			if seen[parent] {
+				break
+			}
+			seen[parent] = true
+		}
+	}
+
+	var roots []*processNode
+	for _, node := range order {
+		if parent, ok := parents[node]; ok {
+			parent.children = append(parent.children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
+
+// processAt - which of a PID's execs was running at the time of an event:
+// the last one before it, or the first if the event came earlier
+func processAt(nodes []*processNode, event tsapi.AlertEvent) *processNode {
+	if len(nodes) == 0 {
+		return nil
+	}
+	current := nodes[0]
+	for _, node := range nodes[1:] {
+		if node.event.Timestamp.After(event.Timestamp.Time) {
+			break
+		}
+		current = node
+	}
+	return current
+}
+
+func writeProcessNode(out io.Writer, node *processNode, prefix string, branch string) {
+	event := node.event
+	line := fmt.Sprintf("[%d] %s", event.PID, eventUser(event))
+	if exe := eventCommandLine(event); exe != "" {
+		line = line + " " + exe
+	}
+	fmt.Fprintf(out, "%s%s%s\n", prefix, branch, line)
+
+	childPrefix := prefix
+	switch branch {
+	case "├── ":
+		childPrefix = prefix + "│   "
+	case "└── ":
+		childPrefix = prefix + "    "
+	}
+	for i, file := range node.files {
+		connector := "│ "
+		if len(node.children) == 0 && i == len(node.files)-1 {
+			connector = "  "
+		}
+		fmt.Fprintf(out, "%s%s file: %s\n", childPrefix, connector, file)
+	}
+	for i, child := range node.children {
+		if i == len(node.children)-1 {
+			writeProcessNode(out, child, childPrefix, "└── ")
+		} else {
+			writeProcessNode(out, child, childPrefix, "├── ")
+		}
+	}
+}
+
+func formatEventTime(event tsapi.AlertEvent) string {
+	if event.Timestamp.IsZero() {
+		return "-"
+	}
+	return event.Timestamp.UTC().Format(time.RFC3339)
+}
+
+func eventKind(event tsapi.AlertEvent) string {
+	if event.Syscall != "" {
+		return event.Syscall
+	}
+	if event.EventType != "" {
+		return event.EventType
+	}
+	return "event"
+}
+
+func eventUser(event tsapi.AlertEvent) string {
+	user := event.User
+	if user == "" {
+		user = "?"
+	}
+	if event.UID != nil {
+		user = fmt.Sprintf("%s(%d)", user, *event.UID)
+	}
+	return user
+}
+
+func eventCommandLine(event tsapi.AlertEvent) string {
+	exe := event.Exe
+	if exe == "" {
+		exe = event.Command
+	}
+	args := event.Args
+	// args[0] is usually the program name, which we already have
+	if len(args) > 0 && (args[0] == exe || strings.HasSuffix(exe, "/"+args[0])) {
+		args = args[1:]
+	}
+	return strings.TrimSpace(exe + " " + strings.Join(args, " "))
+}
+
+func eventSummary(event tsapi.AlertEvent) string {
+	var parts []string
+	if event.PID != 0 {
+		parts = append(parts, fmt.Sprintf("pid=%d ppid=%d", event.PID, event.PPID))
+	}
+	if cmd := eventCommandLine(event); cmd != "" {
+		parts = append(parts, cmd)
+	}
+	if event.Filename != "" {
+		parts = append(parts, "file="+event.Filename)
+	}
+	if event.Success != nil && !*event.Success {
+		parts = append(parts, "(failed)")
+	}
+	if len(parts) == 0 && event.Hostname != "" {
+		parts = append(parts, "host="+event.Hostname)
+	}
+	return strings.Join(parts, " ")
+}
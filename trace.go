@@ -0,0 +1,152 @@
+// ts - golang ts api client
+// trace.go: --trace request logging and curl commands for sharing requests
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tracingTransport logs every API request and response, with per-phase
+// timings, to out
+type tracingTransport struct {
+	next http.RoundTripper
+	out  io.Writer
+	mu   sync.Mutex
+}
+
+// requestTimings collects httptrace callbacks for one request
+type requestTimings struct {
+	start, dnsStart, dnsDone, connectStart, connectDone time.Time
+	tlsStart, tlsDone, gotConn, wroteRequest, firstByte time.Time
+	reused                                              bool
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	timings := &requestTimings{start: time.Now()}
+	trace := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { timings.dnsStart = time.Now() },
+		DNSDone:           func(httptrace.DNSDoneInfo) { timings.dnsDone = time.Now() },
+		ConnectStart:      func(string, string) { timings.connectStart = time.Now() },
+		ConnectDone:       func(string, string, error) { timings.connectDone = time.Now() },
+		TLSHandshakeStart: func() { timings.tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { timings.tlsDone = time.Now() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			timings.gotConn = time.Now()
+			timings.reused = info.Reused
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { timings.wroteRequest = time.Now() },
+		GotFirstResponseByte: func() { timings.firstByte = time.Now() },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	resp, err := t.next.RoundTrip(req)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.out, "> %s %s\n", req.Method, req.URL)
+	writeTraceHeaders(t.out, ">", req.Header)
+	if req.ContentLength > 0 {
+		fmt.Fprintf(t.out, "> (%d byte body)\n", req.ContentLength)
+	}
+	if err != nil {
+		fmt.Fprintf(t.out, "! %s\n", err)
+	} else {
+		fmt.Fprintf(t.out, "< %s %s\n", resp.Proto, resp.Status)
+		writeTraceHeaders(t.out, "<", resp.Header)
+	}
+	fmt.Fprintf(t.out, "* %s\n\n", timings.String())
+	return resp, err
+}
+
+// String summarizes the phases that happened; a reused connection skips
+// DNS, connect and TLS.
+func (r *requestTimings) String() string {
+	phase := func(name string, from, to time.Time) string {
+		if from.IsZero() || to.IsZero() {
+			return ""
+		}
+		return fmt.Sprintf("%s=%s ", name, to.Sub(from).Round(time.Microsecond))
+	}
+	var b strings.Builder
+	b.WriteString(phase("dns", r.dnsStart, r.dnsDone))
+	b.WriteString(phase("connect", r.connectStart, r.connectDone))
+	b.WriteString(phase("tls", r.tlsStart, r.tlsDone))
+	b.WriteString(phase("send", r.gotConn, r.wroteRequest))
+	b.WriteString(phase("wait", r.wroteRequest, r.firstByte))
+	b.WriteString(phase("total", r.start, time.Now()))
+	if r.reused {
+		b.WriteString("(reused connection)")
+	}
+	return "timing: " + strings.TrimSpace(b.String())
+}
+
+// writeTraceHeaders prints headers in a stable order, with the Hawk
+// Authorization header redacted
+func writeTraceHeaders(out io.Writer, prefix string, headers http.Header) {
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range headers[name] {
+			if name == "Authorization" {
+				value = redactAuthorization(value)
+			}
+			fmt.Fprintf(out, "%s %s: %s\n", prefix, name, value)
+		}
+	}
+}
+
+// redactAuthorization keeps the scheme and the Hawk id (the user ID, which
+// support will want to see) but drops the MAC, hash and nonce
+func redactAuthorization(value string) string {
+	scheme := strings.SplitN(value, " ", 2)[0]
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(strings.TrimPrefix(part, scheme))
+		if strings.HasPrefix(part, "id=") {
+			return scheme + " " + part + ", [REDACTED]"
+		}
+	}
+	return scheme + " [REDACTED]"
+}
+
+// curlCommand - an equivalent curl command line for a signed request
+func curlCommand(req *http.Request, payload []byte) string {
+	parts := []string{"curl"}
+	if req.Method != "GET" {
+		parts = append(parts, "-X", req.Method)
+	}
+	var names []string
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range req.Header[name] {
+			parts = append(parts, "-H", shellQuote(name+": "+value))
+		}
+	}
+	if len(payload) > 0 {
+		parts = append(parts, "--data-binary", shellQuote(string(payload)))
+	}
+	parts = append(parts, shellQuote(req.URL.String()))
+	return strings.Join(parts, " ")
+}
+
+// shellQuote single-quotes a string for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
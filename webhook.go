@@ -0,0 +1,221 @@
+// ts - golang ts api client
+// webhook.go: render alerts through a template and POST them to a webhook
+//
+// Copyright 2019-2022 F5 Inc.
+// Licensed under the BSD 3-clause license; see LICENSE for more information.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/template"
+	"time"
+
+	tsapi "github.com/threatstack/ts/api"
+)
+
+// webhookPayload is what webhook templates are rendered with
+type webhookPayload struct {
+	Alert tsapi.Alert
+	Agent *tsapi.Agent
+}
+
+// webhookDeadLetter is written to disk when an alert can't be delivered
+type webhookDeadLetter struct {
+	Alert    tsapi.Alert `json:"alert"`
+	Payload  string      `json:"payload"`
+	Error    string      `json:"error"`
+	Attempts int         `json:"attempts"`
+	FailedAt string      `json:"failedAt"`
+}
+
+// webhookSink POSTs rendered alerts to a URL
+type webhookSink struct {
+	url         string
+	contentType string
+	template    *template.Template
+	secret      []byte
+	retries     int
+	deadLetter  string
+	lookupAgent func(id string) (tsapi.Agent, error)
+	agents      map[string]*tsapi.Agent
+	client      *http.Client
+}
+
+// webhookTemplateFuncs are available to every webhook template
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		ser, err := json.Marshal(v)
+		return string(ser), err
+	},
+	"severityName": func(severity int) string {
+		switch severity {
+		case 1:
+			return "high"
+		case 2:
+			return "medium"
+		case 3:
+			return "low"
+		}
+		return strconv.Itoa(severity)
+	},
+}
+
+// defaultWebhookTemplate sends the alert (and agent, if enriched) as JSON
+const defaultWebhookTemplate = `{"alert":{{json .Alert}}{{if .Agent}},"agent":{{json .Agent}}{{end}}}`
+
+// newWebhookSink - check the destination and load the payload template. An
+// empty templatePath uses defaultWebhookTemplate.
+func newWebhookSink(destination string, templatePath string) (*webhookSink, error) {
+	u, err := url.Parse(destination)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("Invalid webhook URL: %s", destination)
+	}
+
+	tmpl := template.New("webhook").Funcs(webhookTemplateFuncs)
+	if templatePath == "" {
+		tmpl, err = tmpl.Parse(defaultWebhookTemplate)
+	} else {
+		var text []byte
+		text, err = ioutil.ReadFile(templatePath)
+		if err == nil {
+			tmpl, err = tmpl.Parse(string(text))
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load webhook template: %s", err)
+	}
+
+	return &webhookSink{
+		url:         destination,
+		contentType: "application/json",
+		template:    tmpl,
+		retries:     3,
+		agents:      make(map[string]*tsapi.Agent),
+		client:      &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Send renders and delivers a single alert, retrying transient failures.
+// Alerts that still can't be delivered are dead-lettered rather than
+// stopping the forwarder.
+func (s *webhookSink) Send(alert tsapi.Alert) error {
+	payload := webhookPayload{Alert: alert}
+	if s.lookupAgent != nil && alert.AgentID != "" {
+		agent, ok := s.agents[alert.AgentID]
+		if !ok {
+			if found, err := s.lookupAgent(alert.AgentID); err == nil {
+				agent = &found
+			}
+			s.agents[alert.AgentID] = agent
+		}
+		payload.Agent = agent
+	}
+
+	var body bytes.Buffer
+	if err := s.template.Execute(&body, payload); err != nil {
+		return s.deadLetterAlert(alert, "", fmt.Errorf("unable to render template: %s", err), 0)
+	}
+
+	var err error
+	attempt := 0
+	for attempt < s.retries+1 {
+		if attempt > 0 && !pause(time.Duration(1<<uint(attempt-1))*time.Second) {
+			break
+		}
+		attempt++
+		var retry bool
+		retry, err = s.post(body.Bytes())
+		if err == nil || !retry {
+			break
+		}
+	}
+	if appContext.Err() != nil {
+		// Interrupted, not undeliverable: leave it for the next run
+		return appContext.Err()
+	}
+	if err != nil {
+		return s.deadLetterAlert(alert, body.String(), err, attempt)
+	}
+	return nil
+}
+
+// post sends one request, reporting whether a failure is worth retrying
+func (s *webhookSink) post(body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(appContext, "POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", s.contentType)
+	req.Header.Set("User-Agent", "ts-forward")
+	if len(s.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-TS-Timestamp", timestamp)
+		req.Header.Set("X-TS-Signature", "sha256="+signWebhook(s.secret, timestamp, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == 429 || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook responded with an HTTP/%d", resp.StatusCode)
+}
+
+// signWebhook - HMAC-SHA256 over "timestamp.body", so receivers can check
+// both who sent the payload and that it isn't a replay
+func signWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deadLetterAlert - save an undeliverable alert to disk. Without a
+// dead-letter directory the failure is returned to the forwarder instead.
+func (s *webhookSink) deadLetterAlert(alert tsapi.Alert, payload string, cause error, attempts int) error {
+	if s.deadLetter == "" {
+		return cause
+	}
+	if err := os.MkdirAll(s.deadLetter, 0700); err != nil {
+		return err
+	}
+	letter := webhookDeadLetter{
+		Alert:    alert,
+		Payload:  payload,
+		Error:    cause.Error(),
+		Attempts: attempts,
+		FailedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	ser, err := json.MarshalIndent(letter, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%d.json", unsafeFilename.ReplaceAllString(alert.ID, "_"), time.Now().UnixNano())
+	if err := ioutil.WriteFile(filepath.Join(s.deadLetter, name), ser, 0600); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "forward: dead-lettered alert %s: %s\n", alert.ID, cause)
+	return nil
+}
+
+// Close is a no-op; each alert is its own request.
+func (s *webhookSink) Close() error {
+	return nil
+}